@@ -0,0 +1,195 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// CallerEncoderShort encodes the caller as file:line, it is the default caller encoder
+	CallerEncoderShort = "short"
+	// CallerEncoderFull encodes the caller as /absolute/path/file:line
+	CallerEncoderFull = "full"
+	// CallerEncoderModuleRelative encodes the caller as path/relative/to/main/module/file:line,
+	// the callers outside the main module are encoded as import/path/of/package/file:line
+	CallerEncoderModuleRelative = "module-relative"
+	// CallerEncoderPackage encodes the caller as package/file:line
+	CallerEncoderPackage = "package"
+	// CallerEncoderFunction encodes the caller as file:line package.function
+	CallerEncoderFunction = "function"
+
+	goModFile = "go.mod"
+)
+
+var (
+	ErrInvalidCallerEncoder = "invalid caller encoder %s, must be one of short, full, module-relative, package or function."
+
+	// _mainModulePath is the module path of the main module, it is read from the build info
+	_mainModulePath     string
+	_mainModulePathOnce sync.Once
+	// _moduleRoots caches the module root of the directories of the main package, the value is an empty string
+	// if the module root is not found
+	_moduleRoots sync.Map
+)
+
+// validateCallerEncoder returns an error if the caller encoder is not valid, empty name is valid
+func validateCallerEncoder(name string) error {
+	switch strings.ToLower(name) {
+	case "", CallerEncoderShort, CallerEncoderFull, CallerEncoderModuleRelative, CallerEncoderPackage, CallerEncoderFunction:
+		return nil
+	default:
+		return errors.New(fmt.Sprintf(ErrInvalidCallerEncoder, name))
+	}
+}
+
+// DefaultCallerEncoder serializes a caller with the caller encoder of the encoder,
+// it falls back to ShortCallerEncoder for the encoders of other packages
+func DefaultCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	var name string
+	switch e := enc.(type) {
+	case *textEncoder:
+		name = e.callerEncoder
+	case *jsonEncoder:
+		name = e.callerEncoder
+	}
+
+	switch name {
+	case CallerEncoderFull:
+		enc.AppendString(getFullCallerString(caller))
+	case CallerEncoderModuleRelative:
+		enc.AppendString(getModuleRelativeCallerString(caller))
+	case CallerEncoderPackage:
+		enc.AppendString(getPackageCallerString(caller))
+	case CallerEncoderFunction:
+		enc.AppendString(getFunctionCallerString(caller))
+	default:
+		ShortCallerEncoder(caller, enc)
+	}
+}
+
+// getFullCallerString returns the caller in /absolute/path/file:line format
+func getFullCallerString(ec zapcore.EntryCaller) string {
+	if !ec.Defined {
+		return "<unknown>"
+	}
+
+	return ec.File + ":" + strconv.Itoa(ec.Line)
+}
+
+// getPackageCallerString returns the caller in package/file:line format
+func getPackageCallerString(ec zapcore.EntryCaller) string {
+	if !ec.Defined {
+		return "<unknown>"
+	}
+
+	return ec.TrimmedPath()
+}
+
+// getFunctionCallerString returns the caller in file:line package.function format
+func getFunctionCallerString(ec zapcore.EntryCaller) string {
+	caller := getCallerString(ec)
+	if !ec.Defined || ec.Function == "" {
+		return caller
+	}
+
+	return caller + " " + ec.Function[strings.LastIndexByte(ec.Function, '/')+1:]
+}
+
+// getModuleRelativeCallerString returns the caller in path/relative/to/main/module/file:line format,
+// the callers outside the main module are returned in import/path/of/package/file:line format,
+// and it falls back to package/file:line format if the path could not be decided
+func getModuleRelativeCallerString(ec zapcore.EntryCaller) string {
+	if !ec.Defined {
+		return "<unknown>"
+	}
+
+	line := ":" + strconv.Itoa(ec.Line)
+	file := filepath.Base(ec.File)
+	module := getMainModulePath()
+	pkg := getCallerPackage(ec.Function)
+	switch {
+	case pkg == "main":
+		// the import path of the main package is unknown, so the module root is looked up by go.mod
+		root := getModuleRoot(filepath.Dir(ec.File))
+		if root != "" {
+			rel, err := filepath.Rel(root, ec.File)
+			if err == nil {
+				return filepath.ToSlash(rel) + line
+			}
+		}
+	case pkg != "" && module != "" && pkg == module:
+		return file + line
+	case pkg != "" && module != "" && strings.HasPrefix(pkg, module+"/"):
+		return pkg[len(module)+1:] + "/" + file + line
+	case pkg != "":
+		return pkg + "/" + file + line
+	case module != "" && strings.HasPrefix(ec.File, module+"/"):
+		// the binary is built with -trimpath
+		return ec.File[len(module)+1:] + line
+	}
+
+	return ec.TrimmedPath()
+}
+
+// getMainModulePath returns the module path of the main module, it returns an empty string
+// if the binary is built without module support
+func getMainModulePath() string {
+	_mainModulePathOnce.Do(func() {
+		bi, ok := debug.ReadBuildInfo()
+		if ok {
+			_mainModulePath = bi.Main.Path
+		}
+	})
+
+	return _mainModulePath
+}
+
+// getCallerPackage returns the import path of the package of the function,
+// the function is like github.com/romberli/log.(*Logger).Info
+func getCallerPackage(function string) string {
+	if function == "" {
+		return ""
+	}
+
+	slash := strings.LastIndexByte(function, '/')
+	dot := strings.IndexByte(function[slash+1:], '.')
+	if dot < 0 {
+		return ""
+	}
+
+	return function[:slash+1+dot]
+}
+
+// getModuleRoot returns the nearest parent directory of dir which contains go.mod,
+// it returns an empty string if go.mod is not found, the result is cached
+func getModuleRoot(dir string) string {
+	root, ok := _moduleRoots.Load(dir)
+	if ok {
+		return root.(string)
+	}
+
+	cur := dir
+	for {
+		_, err := os.Stat(filepath.Join(cur, goModFile))
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(cur)
+		if parent == cur {
+			cur = ""
+			break
+		}
+		cur = parent
+	}
+	_moduleRoots.Store(dir, cur)
+
+	return cur
+}
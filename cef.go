@@ -0,0 +1,253 @@
+package log
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultCEFVendor is the Device Vendor of the header if it is not specified
+	DefaultCEFVendor = "unknown"
+	// DefaultCEFVersion is the Device Version of the header if it is not specified
+	DefaultCEFVersion = "0"
+
+	cefVersion = "0"
+
+	// the extension keys of the entry values, see the CEF implementation standard
+	cefTimeKey     = "rt"
+	cefHostKey     = "dvchost"
+	cefPIDKey      = "dvcpid"
+	cefFacilityKey = "deviceFacility"
+	// cefCustomStringKey is the prefix of the custom string keys, which are cs1 to cs6,
+	// the label of cs1 is cs1Label and so on
+	cefCustomStringKey   = "cs"
+	cefCustomLabelSuffix = "Label"
+)
+
+// CEFConfig serializes cef format related config in yaml/json.
+type CEFConfig struct {
+	// Vendor is the Device Vendor of the header, default is DefaultCEFVendor.
+	Vendor string `yaml:"vendor" json:"vendor"`
+	// Product is the Device Product of the header, default is the base name of the executable.
+	Product string `yaml:"product" json:"product"`
+	// Version is the Device Version of the header, default is DefaultCEFVersion.
+	Version string `yaml:"version" json:"version"`
+	// Host is the dvchost of the extension, default is the host name reported by the kernel.
+	Host string `yaml:"host" json:"host"`
+}
+
+// cefSeverity returns the cef severity of the level, which is from 0 to 10
+func cefSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 0
+	case zapcore.InfoLevel:
+		return 3
+	case zapcore.WarnLevel:
+		return 5
+	case zapcore.ErrorLevel:
+		return 7
+	case zapcore.DPanicLevel:
+		return 8
+	case zapcore.PanicLevel:
+		return 9
+	case zapcore.FatalLevel:
+		return 10
+	default:
+		return 3
+	}
+}
+
+// cefHeader is the static part of the cef messages, it is shared by the clones of the encoder
+type cefHeader struct {
+	// prefix is the escaped CEF:Version|Device Vendor|Device Product|Device Version| of the header
+	prefix string
+	host   string
+	pid    string
+}
+
+// newCEFHeader returns a *cefHeader
+func newCEFHeader(cc CEFConfig) *cefHeader {
+	vendor := cc.Vendor
+	if vendor == "" {
+		vendor = DefaultCEFVendor
+	}
+	version := cc.Version
+	if version == "" {
+		version = DefaultCEFVersion
+	}
+	prefix := "CEF:" + cefVersion
+	for _, field := range []string{vendor, getAppName(cc.Product), version} {
+		prefix += "|" + escapeCEFHeaderField(field)
+	}
+
+	return &cefHeader{
+		prefix: prefix + "|",
+		host:   getHostname(cc.Host),
+		pid:    strconv.Itoa(os.Getpid()),
+	}
+}
+
+// writeParam writes the param as a space and key=value
+func (h *cefHeader) writeParam(enc *textEncoder, name, val string, number bool) {
+	enc.buf.AppendByte(' ')
+	enc.buf.AppendString(sanitizeCEFKey(name))
+	enc.buf.AppendByte('=')
+	appendCEFValue(enc.buf, val)
+}
+
+// cefEncoder encodes the entries in ArcSight CEF format, the level is mapped to the severity,
+// the message is the Name of the header, the caller, function and stack are added as the custom strings,
+// and the fields are added as the extensions, the namespaces and the nested objects are flattened into dotted keys
+type cefEncoder struct {
+	*flatEncoder
+	header *cefHeader
+}
+
+// NewCEFEncoder creates a cef encoder, the header is specified by Config.CEF
+func NewCEFEncoder(cfg *Config) zapcore.Encoder {
+	return newCEFEncoder(cfg)
+}
+
+// newCEFEncoder returns a *cefEncoder
+func newCEFEncoder(cfg *Config) *cefEncoder {
+	header := newCEFHeader(cfg.CEF)
+
+	return &cefEncoder{
+		flatEncoder: newFlatEncoder(cfg, LogFormatCEF, header),
+		header:      header,
+	}
+}
+
+func (enc *cefEncoder) Clone() zapcore.Encoder {
+	return &cefEncoder{
+		flatEncoder: enc.flatEncoder.clone(),
+		header:      enc.header,
+	}
+}
+
+func (enc *cefEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := enc.cloned()
+	final.buf.AppendString(enc.header.prefix)
+	// Device Event Class ID is the level, and Name is the message
+	final.buf.AppendString(ent.Level.CapitalString())
+	final.buf.AppendByte('|')
+	msg := final.limiter.truncateMessage(ent.Message)
+	if final.scanner != nil {
+		msg = final.scanner.scan(msg)
+	}
+	appendCEFHeaderField(final.buf, msg)
+	final.buf.AppendByte('|')
+	final.buf.AppendInt(int64(cefSeverity(ent.Level)))
+	final.buf.AppendByte('|')
+
+	// the extensions are separated by spaces, dvchost is always the first one, so it has no leading space
+	final.buf.AppendString(cefHostKey)
+	final.buf.AppendByte('=')
+	appendCEFValue(final.buf, enc.header.host)
+	final.writeParam(cefPIDKey, enc.header.pid, true)
+	if final.TimeKey != "" {
+		final.writeParam(cefTimeKey, strconv.FormatInt(ent.Time.UnixNano()/int64(time.Millisecond), 10), true)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.writeParam(cefFacilityKey, ent.LoggerName, false)
+	}
+	custom := 0
+	addCustomString := func(label, val string) {
+		custom++
+		key := cefCustomStringKey + strconv.Itoa(custom)
+		final.writeParam(key+cefCustomLabelSuffix, label, false)
+		final.writeParam(key, val, false)
+	}
+	if ent.Caller.Defined && final.CallerKey != "" {
+		addCustomString(final.CallerKey, final.caller(ent.Caller))
+	}
+	if ent.Caller.Defined && final.FunctionKey != "" {
+		addCustomString(final.FunctionKey, ent.Caller.Function)
+	}
+	if stack := final.stack(ent); stack != "" {
+		addCustomString(final.StacktraceKey, stack)
+	}
+	_, _ = final.buf.Write(enc.buf.Bytes())
+	final.addFields(fields)
+	final.addLineEnding()
+	final.truncateEntry()
+
+	ret := final.buf
+	putTextEncoder(final.textEncoder)
+	return ret, nil
+}
+
+// escapeCEFHeaderField escapes the field of the header
+func escapeCEFHeaderField(field string) string {
+	buf := _pool.Get()
+	appendCEFHeaderField(buf, field)
+	field = buf.String()
+	buf.Free()
+
+	return field
+}
+
+// appendCEFHeaderField appends the field of the header, the backslashes and the pipes are escaped,
+// and the line breaks are replaced with spaces, as they are not allowed in the header
+func appendCEFHeaderField(buf *buffer.Buffer, field string) {
+	for i := 0; i < len(field); i++ {
+		switch b := field[i]; b {
+		case '\\', '|':
+			buf.AppendByte('\\')
+			buf.AppendByte(b)
+		case '\r', '\n':
+			buf.AppendByte(' ')
+		default:
+			buf.AppendByte(b)
+		}
+	}
+}
+
+// appendCEFValue appends the value of the extension, the backslashes and the equal signs are escaped,
+// and the line breaks are escaped as \r and \n
+func appendCEFValue(buf *buffer.Buffer, val string) {
+	for i := 0; i < len(val); {
+		b := val[i]
+		switch b {
+		case '\\', '=':
+			buf.AppendByte('\\')
+			buf.AppendByte(b)
+		case '\r':
+			buf.AppendString(`\r`)
+		case '\n':
+			buf.AppendString(`\n`)
+		default:
+			if b < utf8.RuneSelf {
+				buf.AppendByte(b)
+				break
+			}
+			r, size := utf8.DecodeRuneInString(val[i:])
+			if r == utf8.RuneError && size == 1 {
+				buf.AppendString("\ufffd")
+			} else {
+				buf.AppendString(val[i : i+size])
+			}
+			i += size
+			continue
+		}
+		i++
+	}
+}
+
+// sanitizeCEFKey replaces the characters which are not allowed in the extension keys with underscores,
+// the allowed characters are letters, digits, underscores and dots
+func sanitizeCEFKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, key)
+}
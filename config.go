@@ -18,7 +18,6 @@ import (
 	"os"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/pingcap/errors"
@@ -129,23 +128,71 @@ func NewEmptyFileLogConfig() *FileLogConfig {
 	return &FileLogConfig{}
 }
 
+// EncoderConfig serializes the key names and the layout of the encoders in yaml/json,
+// empty key uses the default key name, and EncoderKeyOmit omits the key from output.
+type EncoderConfig struct {
+	TimeKey       string `yaml:"time-key" json:"time-key"`
+	LevelKey      string `yaml:"level-key" json:"level-key"`
+	NameKey       string `yaml:"name-key" json:"name-key"`
+	CallerKey     string `yaml:"caller-key" json:"caller-key"`
+	FunctionKey   string `yaml:"function-key" json:"function-key"`
+	MessageKey    string `yaml:"message-key" json:"message-key"`
+	StacktraceKey string `yaml:"stacktrace-key" json:"stacktrace-key"`
+	// LevelEncoder is one of capital, lower, capital-color or color, default is capital,
+	// capital-color and color fall back to capital and lower if the console format colorizes the level itself.
+	LevelEncoder string `yaml:"level-encoder" json:"level-encoder"`
+	// DurationEncoder is one of string, seconds, millis or nanos, default is string.
+	DurationEncoder string `yaml:"duration-encoder" json:"duration-encoder"`
+	// NameEncoder is one of full or short, default is full.
+	NameEncoder string `yaml:"name-encoder" json:"name-encoder"`
+	// CallerEncoder is one of short, full, module-relative, package or function, default is short.
+	CallerEncoder string `yaml:"caller-encoder" json:"caller-encoder"`
+	// ErrorEncoder is one of verbose or chain, default is verbose.
+	ErrorEncoder string `yaml:"error-encoder" json:"error-encoder"`
+	// UseTextMarshaler encodes the reflected values which implement encoding.TextMarshaler with MarshalText()
+	// instead of json reflection, the encoders registered by RegisterTypeEncoder() are preferred.
+	UseTextMarshaler bool `yaml:"use-text-marshaler" json:"use-text-marshaler"`
+	// UseStringer encodes the reflected values which implement fmt.Stringer with String()
+	// instead of json reflection, encoding.TextMarshaler is preferred if UseTextMarshaler is true.
+	UseStringer bool `yaml:"use-stringer" json:"use-stringer"`
+	// DottedKeys flattens the namespaces and the nested objects into dotted keys in text and console format,
+	// for example: [http.request.method=GET], the arrays are not flattened.
+	DottedKeys bool `yaml:"dotted-keys" json:"dotted-keys"`
+}
+
 // Config serializes log related config in yaml/json.
 type Config struct {
 	// Log level.
 	Level string `yaml:"level" json:"level"`
-	// Log format. one of json, text, or console.
+	// Log format. one of json, text, console, syslog, gelf, cef or glog.
 	Format string `yaml:"format" json:"format"`
 	// Disable automatic timestamps in output.
 	DisableTimestamp bool `yaml:"disable-timestamp" json:"disable-timestamp"`
+	// TimeFormat is the layout of the timestamps in output, default is TimeFormatMicroSecond,
+	// rfc3339nano, iso8601, unix, unix-millis and unix-micros are also supported.
+	TimeFormat string `yaml:"time-format" json:"time-format"`
+	// TimeZone is UTC, Local or an IANA time zone name like Asia/Shanghai, default is the local time zone.
+	TimeZone string `yaml:"time-zone" json:"time-zone"`
 	// File log config.
 	File FileLogConfig `yaml:"file" json:"file"`
+	// Encoder config, it controls the key names and the layout of the encoders.
+	Encoder EncoderConfig `yaml:"encoder" json:"encoder"`
 	// Development puts the logger in development mode, which changes the
 	// behavior of DPanicLevel and takes stacktraces more liberally.
 	Development bool `yaml:"development" json:"development"`
-	// DisableDoubleQuote disables adding double-quotes to log entry
+	// Layout is the pattern layout of text format, for example: "%time %-5level [%caller] %msg %fields",
+	// see parseLayout() for the syntax, empty layout uses the default bracketed layout,
+	// the fields and the stack are appended if the layout does not contain %fields, an invalid layout fails the logger,
+	// while NewTextEncoder() falls back to the default layout.
+	Layout string `yaml:"layout" json:"layout"`
+	// DisableDoubleQuote disables adding double-quotes to log entry, the strings containing spaces, brackets, = or {
+	// are not quoted either, so the output is ambiguous and could not always be parsed back by Parser
 	DisableDoubleQuotes bool
 	// DisableEscape disables escaping special characters like \n,\r...
 	DisableEscape bool
+	// Color colorizes the level of console format, one of auto, always or never,
+	// auto colorizes the level only if all the outputs are terminals.
+	Color string `yaml:"color" json:"color"`
 	// DisableCaller stops annotating logs with the calling function's file
 	// name and line number. By default, all logs are annotated.
 	DisableCaller bool `yaml:"disable-caller" json:"disable-caller"`
@@ -156,12 +203,42 @@ type Config struct {
 	// DisableErrorVerbose stops annotating logs with the full verbose error
 	// message.
 	DisableErrorVerbose bool `yaml:"disable-error-verbose" json:"disable-error-verbose"`
+	// Redact config, the values of the sensitive keys will be redacted.
+	Redact RedactConfig `yaml:"redact" json:"redact"`
+	// Scan config, the secrets in the messages and the string values will be masked.
+	Scan ScanConfig `yaml:"scan" json:"scan"`
+	// Limit config, the values which exceed the size limits will be truncated.
+	Limit LimitConfig `yaml:"limit" json:"limit"`
+	// Stack config, the stack traces could be parsed into frames, filtered and capped.
+	Stack StackConfig `yaml:"stack" json:"stack"`
+	// Syslog config, it specifies the protocol, facility and header fields of syslog format.
+	Syslog SyslogConfig `yaml:"syslog" json:"syslog"`
+	// GELF config, it specifies the host of gelf format.
+	GELF GELFConfig `yaml:"gelf" json:"gelf"`
+	// CEF config, it specifies the vendor, product and version of the header of cef format.
+	CEF CEFConfig `yaml:"cef" json:"cef"`
+	// Verbosity is the threshold of V(), V(level) logs at InfoLevel only if level is not larger than it,
+	// it is shared by all the loggers like the -v flag of glog and klog, see SetVerbosity(),
+	// zero verbosity leaves the threshold unchanged, and the -v flag takes precedence over it.
+	Verbosity int `yaml:"verbosity" json:"verbosity"`
+	// Outputs are the additional outputs, each of them has its own level, format and encoder options,
+	// the entries are written to the file or stdout of the logger and all the outputs.
+	Outputs []OutputConfig `yaml:"outputs" json:"outputs"`
+	// Routes write the entries in their level ranges to their own log files, for example: the errors to error.log,
+	// the entries are also written to the file of the logger, Rotate() and Sync() apply to all the routes.
+	Routes []RouteConfig `yaml:"routes" json:"routes"`
+	// Dedup config, the duplicates in a time window are suppressed and summarized, nil means deduplication is disabled,
+	// the outputs and the routes suppress their own duplicates.
+	Dedup *DedupConfig `yaml:"dedup" json:"dedup"`
 	// SamplingConfig sets a sampling strategy for the logger. Sampling caps the
 	// global CPU and I/O load that logging puts on your process while attempting
 	// to preserve a representative subset of your logs.
 	//
 	// Values configured here are per-second. See zapcore.NewSampler for details.
 	Sampling *zap.SamplingConfig `yaml:"sampling" json:"sampling"`
+	// Sampler config, it samples the entries with the rules of the levels, the tick and the key field,
+	// and counts the sampled and the dropped entries, Sampling is ignored if it is set.
+	Sampler *SamplerConfig `yaml:"sampler" json:"sampler"`
 }
 
 // NewConfig creates a Config.
@@ -211,6 +288,18 @@ func (cfg *Config) SetDisableEscape(disableEscape bool) {
 	cfg.DisableEscape = disableEscape
 }
 
+// enableColor returns true if the level of console format should be colorized with given output
+func (cfg *Config) enableColor(output zapcore.WriteSyncer) bool {
+	switch strings.ToLower(cfg.Color) {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	default:
+		return output != nil && isTerminalWriteSyncer(output)
+	}
+}
+
 // buildOptions returns []zap.Option with options of config
 func (cfg *Config) buildOptions(errSink zapcore.WriteSyncer) []zap.Option {
 	opts := []zap.Option{zap.ErrorOutput(errSink)}
@@ -231,9 +320,9 @@ func (cfg *Config) buildOptions(errSink zapcore.WriteSyncer) []zap.Option {
 		opts = append(opts, zap.AddStacktrace(stackLevel))
 	}
 
-	if cfg.Sampling != nil {
+	if cfg.Sampling != nil && cfg.Sampler == nil {
 		opts = append(opts, zap.WrapCore(func(core zapcore.Core) zapcore.Core {
-			return zapcore.NewSamplerWithOptions(core, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter)
+			return NewSampler(core, defaultSamplingTick, cfg.Sampling.Initial, cfg.Sampling.Thereafter, cfg.Sampling.Hook)
 		}))
 	}
 
@@ -275,8 +364,3 @@ func (props *ZapProperties) WithCore(core zapcore.Core) *ZapProperties {
 		level,
 	}
 }
-
-// newZapTextEncoder returns zapcore.Encoder with given config
-func newZapTextEncoder(cfg *Config) zapcore.Encoder {
-	return NewTextEncoder(cfg)
-}
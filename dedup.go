@@ -0,0 +1,269 @@
+package log
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romberli/go-multierror"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultDedupWindow is the default time window of deduplication
+	DefaultDedupWindow = time.Minute
+	// DefaultDedupMaxKeys is the default maximum number of the keys in the open windows
+	DefaultDedupMaxKeys = 1000
+	// DedupRepeatedKey is the key of the number of the suppressed duplicates in the summary entry
+	DedupRepeatedKey = "repeated"
+	// DedupFirstKey is the key of the time of the first occurrence in the summary entry
+	DedupFirstKey = "first"
+	// DedupLastKey is the key of the time of the last suppressed duplicate in the summary entry
+	DedupLastKey = "last"
+)
+
+// DedupConfig serializes the config of deduplication in yaml/json,
+// the entries with the same level, message and caller in a time window are written only once,
+// and a summary entry with the number of the suppressed duplicates is written when the window closes or on Sync().
+type DedupConfig struct {
+	// Window is the time window, which starts at the first occurrence, default is DefaultDedupWindow.
+	Window time.Duration `yaml:"window" json:"window"`
+	// Fields adds the fields of the entries to the key of deduplication,
+	// so the entries with different fields are not duplicates,
+	// only the fields of the primitive types like strings, numbers, booleans, durations and times are compared,
+	// the fields of the other types like errors, objects and arrays are excluded from the key.
+	Fields bool `yaml:"fields" json:"fields"`
+	// MaxKeys is the maximum number of the keys in the open windows, default is DefaultDedupMaxKeys,
+	// the entries with new keys are written without deduplication until some windows close.
+	MaxKeys int `yaml:"max-keys" json:"max-keys"`
+}
+
+// dedupState is the state of the entries with the same key in a window
+type dedupState struct {
+	key string
+	// core is the core which wrote the first occurrence, the summary entry is written by it
+	core     *textIOCore
+	ent      zapcore.Entry
+	count    int
+	last     time.Time
+	deadline time.Time
+}
+
+// summary returns the fields of the summary entry
+func (st *dedupState) summary() []zapcore.Field {
+	return []zapcore.Field{
+		zap.Int(DedupRepeatedKey, st.count),
+		zap.Time(DedupFirstKey, st.ent.Time),
+		zap.Time(DedupLastKey, st.last),
+	}
+}
+
+// write writes the summary entry by the core which wrote the first occurrence, nothing is written without duplicates
+func (st *dedupState) write() error {
+	if st.count == 0 {
+		return nil
+	}
+	ent := st.ent
+	ent.Time = st.last
+
+	return st.core.write(ent, st.summary())
+}
+
+// deduper suppresses the duplicates written by a core in the time windows, it is shared by the clones of the core,
+// the windows are closed by a single timer which is armed for the earliest open window
+type deduper struct {
+	window  time.Duration
+	fields  bool
+	maxKeys int
+	// errorOutput is the error output of the logger, the errors of writing the summaries are reported to it
+	errorOutput zapcore.WriteSyncer
+	// now returns the current time, it is replaced by the tests
+	now func() time.Time
+
+	mu     sync.Mutex
+	states map[string]*dedupState
+	// queue is the states in the order of their deadlines, as the windows have the same length
+	queue []*dedupState
+	timer *time.Timer
+}
+
+// newDeduper returns a *deduper with the config, the errors of writing the summaries are reported to errorOutput
+func newDeduper(cfg *DedupConfig, errorOutput zapcore.WriteSyncer) *deduper {
+	window := cfg.Window
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+	maxKeys := cfg.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultDedupMaxKeys
+	}
+
+	return &deduper{
+		window:      window,
+		fields:      cfg.Fields,
+		maxKeys:     maxKeys,
+		errorOutput: errorOutput,
+		now:         time.Now,
+		states:      make(map[string]*dedupState),
+	}
+}
+
+// appendKey appends the key of deduplication to buf, the fields added by With() and the entry are included
+// if d.fields is true, the fields are keyed on their types and their primitive values,
+// the fields of the other types are skipped, as formatting them allocates and calls the marshalers
+func (d *deduper) appendKey(buf []byte, c *textIOCore, ent zapcore.Entry, fields []zapcore.Field) []byte {
+	buf = append(buf, byte(ent.Level))
+	buf = append(buf, ent.Message...)
+	buf = append(buf, 0)
+	if ent.Caller.Defined {
+		buf = append(buf, ent.Caller.File...)
+		buf = append(buf, ':')
+		buf = appendInt(buf, int64(ent.Caller.Line))
+	}
+	if !d.fields {
+		return buf
+	}
+	for _, fs := range [][]zapcore.Field{c.fields, fields} {
+		for _, f := range fs {
+			buf = appendFieldKey(buf, f)
+		}
+	}
+
+	return buf
+}
+
+// appendFieldKey appends the key, the type and the value of the field if the type is primitive
+func appendFieldKey(buf []byte, f zapcore.Field) []byte {
+	switch f.Type {
+	case zapcore.BoolType, zapcore.DurationType, zapcore.Float64Type, zapcore.Float32Type,
+		zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type,
+		zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type, zapcore.UintptrType,
+		zapcore.TimeType:
+		// the value is in the integer, the location of TimeType does not change the instant
+		buf = appendFieldType(buf, f)
+		return appendInt(buf, f.Integer)
+	case zapcore.StringType:
+		buf = appendFieldType(buf, f)
+		return append(buf, f.String...)
+	case zapcore.ByteStringType, zapcore.BinaryType:
+		b, _ := f.Interface.([]byte)
+		buf = appendFieldType(buf, f)
+		return append(buf, b...)
+	case zapcore.TimeFullType:
+		t, _ := f.Interface.(time.Time)
+		buf = appendFieldType(buf, f)
+		return appendInt(buf, t.UnixNano())
+	default:
+		return buf
+	}
+}
+
+// appendFieldType appends the separator, the key and the type of the field
+func appendFieldType(buf []byte, f zapcore.Field) []byte {
+	buf = append(buf, 0)
+	buf = append(buf, f.Key...)
+	return append(buf, '=', byte(f.Type))
+}
+
+// appendInt appends the little endian bytes of the integer, it is shorter than the decimal
+func appendInt(buf []byte, n int64) []byte {
+	for i := 0; i < 8; i++ {
+		buf = append(buf, byte(uint64(n)>>(8*i)))
+	}
+
+	return buf
+}
+
+// pass returns true if the entry is the first occurrence in the window and should be written,
+// the duplicates are counted and suppressed, the entries are always written if there are too many keys
+func (d *deduper) pass(c *textIOCore, ent zapcore.Entry, fields []zapcore.Field) bool {
+	buf := _pool.Get()
+	defer buf.Free()
+	key := d.appendKey(buf.Bytes(), c, ent, fields)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the conversion does not allocate in the map index
+	st, ok := d.states[string(key)]
+	if ok {
+		st.count++
+		st.last = ent.Time
+		return false
+	}
+	if len(d.states) >= d.maxKeys {
+		return true
+	}
+
+	st = &dedupState{key: string(key), core: c, ent: ent, deadline: d.now().Add(d.window)}
+	st.ent.Stack = ""
+	d.states[st.key] = st
+	d.queue = append(d.queue, st)
+	if len(d.queue) == 1 {
+		d.arm(d.window)
+	}
+
+	return true
+}
+
+// arm arms the timer to sweep the windows after the delay, d.mu must be held
+func (d *deduper) arm(delay time.Duration) {
+	if d.timer == nil {
+		d.timer = time.AfterFunc(delay, d.sweep)
+		return
+	}
+	d.timer.Reset(delay)
+}
+
+// sweep closes the windows which have ended, the summary entries are written if there are duplicates,
+// and the timer is armed for the next open window
+func (d *deduper) sweep() {
+	now := d.now()
+
+	d.mu.Lock()
+	var expired []*dedupState
+	for len(d.queue) > 0 && !d.queue[0].deadline.After(now) {
+		st := d.queue[0]
+		d.queue[0] = nil
+		d.queue = d.queue[1:]
+		delete(d.states, st.key)
+		expired = append(expired, st)
+	}
+	if len(d.queue) > 0 {
+		d.arm(d.queue[0].deadline.Sub(now))
+	}
+	d.mu.Unlock()
+
+	for _, st := range expired {
+		d.reportError(st.write())
+	}
+}
+
+// reportError reports the error of writing a summary to the error output like zap does
+func (d *deduper) reportError(err error) {
+	if err == nil || d.errorOutput == nil {
+		return
+	}
+	_, _ = fmt.Fprintf(d.errorOutput, "%v write error: %v\n", d.now(), err)
+	_ = d.errorOutput.Sync()
+}
+
+// flush closes all the windows, and writes the summary entries of the duplicates
+func (d *deduper) flush() error {
+	d.mu.Lock()
+	queue := d.queue
+	d.states = make(map[string]*dedupState)
+	d.queue = nil
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.mu.Unlock()
+
+	var err *multierror.Error
+	for _, st := range queue {
+		err = multierror.Append(err, st.write())
+	}
+
+	return err.ErrorOrNil()
+}
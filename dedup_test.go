@@ -0,0 +1,169 @@
+package log
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// stepClock returns the times one second apart from 2024-01-02 03:04:05 UTC
+type stepClock struct {
+	n time.Duration
+}
+
+// Now returns the next time
+func (c *stepClock) Now() time.Time {
+	c.n++
+	return time.Date(2024, 1, 2, 3, 4, 4, 0, time.UTC).Add(c.n * time.Second)
+}
+
+// NewTicker returns a ticker of the wall clock
+func (c *stepClock) NewTicker(d time.Duration) *time.Ticker {
+	return time.NewTicker(d)
+}
+
+// failedWriter fails all the writes
+type failedWriter struct{}
+
+// Write returns an error
+func (failedWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk is full")
+}
+
+// newDedupTestLogger returns a text logger with the deduper which writes to b,
+// the entries have the times of stepClock, and the deduper has a clock which is set by the returned function
+func newDedupTestLogger(t *testing.T, b *lockedBuilder, dc *DedupConfig) (*Logger, *deduper, func(time.Duration)) {
+	cfg := newOutputTestConfig(DefaultLogLevel, LogFormatText)
+	cfg.Dedup = dc
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(b), zap.WithClock(&stepClock{}))
+	assert.Nil(t, err, "init logger failed")
+	core, err := getTextIOCore(zapLogger.Core())
+	assert.Nil(t, err, "get text io core failed")
+
+	start := time.Now()
+	now := start
+	if core.dedup != nil {
+		core.dedup.now = func() time.Time { return now }
+	}
+
+	return NewMyLogger(zapLogger), core.dedup, func(d time.Duration) { now = start.Add(d) }
+}
+
+func TestDedup(t *testing.T) {
+	asst := assert.New(t)
+
+	type entry struct {
+		message string
+		fields  []zap.Field
+	}
+	retry := func(attempt int) entry { return entry{"retry failed", []zap.Field{zap.Int("attempt", attempt)}} }
+	cases := []struct {
+		name     string
+		config   DedupConfig
+		entries  []entry
+		expected string
+	}{
+		{
+			"duplicates are suppressed",
+			DedupConfig{Window: time.Hour},
+			[]entry{retry(0), retry(1), retry(2), {message: "another message"}},
+			"[WARN][\"retry failed\"] [service=log][attempt=0]\n" +
+				"[WARN][\"another message\"] [service=log]\n" +
+				"[WARN][\"retry failed\"] [service=log][repeated=2][first=2024-01-02 03:04:05.000000][last=2024-01-02 03:04:07.000000]\n",
+		},
+		{
+			"different fields are not duplicates",
+			DedupConfig{Window: time.Hour, Fields: true},
+			[]entry{retry(0), retry(1), retry(0)},
+			"[WARN][\"retry failed\"] [service=log][attempt=0]\n" +
+				"[WARN][\"retry failed\"] [service=log][attempt=1]\n" +
+				"[WARN][\"retry failed\"] [service=log][repeated=1][first=2024-01-02 03:04:05.000000][last=2024-01-02 03:04:07.000000]\n",
+		},
+		{
+			"non-primitive fields are excluded from the key",
+			DedupConfig{Window: time.Hour, Fields: true},
+			[]entry{
+				{"query failed", []zap.Field{zap.Error(errors.New("timeout"))}},
+				{"query failed", []zap.Field{zap.Error(errors.New("refused"))}},
+			},
+			"[WARN][\"query failed\"] [service=log][error=timeout]\n" +
+				"[WARN][\"query failed\"] [service=log][repeated=1][first=2024-01-02 03:04:05.000000][last=2024-01-02 03:04:06.000000]\n",
+		},
+		{
+			"new keys pass through when the windows are full",
+			DedupConfig{Window: time.Hour, MaxKeys: 1},
+			[]entry{retry(0), {message: "another message"}, {message: "another message"}, retry(1)},
+			"[WARN][\"retry failed\"] [service=log][attempt=0]\n" +
+				"[WARN][\"another message\"] [service=log]\n" +
+				"[WARN][\"another message\"] [service=log]\n" +
+				"[WARN][\"retry failed\"] [service=log][repeated=1][first=2024-01-02 03:04:05.000000][last=2024-01-02 03:04:08.000000]\n",
+		},
+	}
+	for _, c := range cases {
+		var text lockedBuilder
+		logger, _, _ := newDedupTestLogger(t, &text, &c.config)
+		child := NewMyLogger(logger.zapLogger.With(zap.String("service", "log")))
+		for _, e := range c.entries {
+			child.Warn(e.message, e.fields...)
+		}
+		asst.Nil(logger.Sync(), c.name)
+		asst.Equal(c.expected, text.String(), c.name)
+	}
+}
+
+func TestDedupSweep(t *testing.T) {
+	asst := assert.New(t)
+
+	var text lockedBuilder
+	logger, d, setNow := newDedupTestLogger(t, &text, &DedupConfig{Window: time.Hour})
+	logger.Warn("first")
+	logger.Warn("first")
+	setNow(30 * time.Minute)
+	logger.Warn("second")
+	logger.Warn("second")
+
+	// only the windows which have ended are closed
+	setNow(time.Hour)
+	d.sweep()
+	expected := "[WARN][first]\n[WARN][second]\n" +
+		"[WARN][first][repeated=1][first=2024-01-02 03:04:05.000000][last=2024-01-02 03:04:06.000000]\n"
+	asst.Equal(expected, text.String())
+	asst.Equal(1, len(d.states), "the open window should be kept")
+
+	// a new window starts after the window is closed
+	logger.Warn("first")
+	asst.Equal(expected+"[WARN][first]\n", text.String())
+
+	setNow(2 * time.Hour)
+	d.sweep()
+	expected += "[WARN][first]\n[WARN][second][repeated=1][first=2024-01-02 03:04:07.000000][last=2024-01-02 03:04:08.000000]\n"
+	asst.Equal(expected, text.String())
+	asst.Equal(0, len(d.states), "all the windows should be closed")
+	asst.Equal(0, len(d.queue), "all the windows should be closed")
+}
+
+func TestDedupErrorOutput(t *testing.T) {
+	asst := assert.New(t)
+
+	// the errors of writing the summaries of the outputs are reported to the output of the logger
+	var text lockedBuilder
+	logger, _, _ := newDedupTestLogger(t, &text, nil)
+	output := newOutputTestConfig(DefaultLogLevel, LogFormatText)
+	output.Dedup = &DedupConfig{Window: time.Hour}
+	asst.Nil(logger.AddWriteSyncerWithConfig(zapcore.AddSync(failedWriter{}), output), "add output failed")
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	asst.Nil(err, "get text io core failed")
+	d := core.outputs.load().cores[0].dedup
+	d.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	logger.Warn("message")
+	logger.Warn("message")
+	text = lockedBuilder{}
+	d.queue[0].deadline = time.Time{}
+	d.sweep()
+	asst.Equal("2024-01-02 03:04:05 +0000 UTC write error: disk is full\n", text.String())
+}
@@ -0,0 +1,385 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// LogFormatText is the bracketed text format, it is the default log format
+	LogFormatText = "text"
+	// LogFormatJSON is the json lines format
+	LogFormatJSON = "json"
+	// LogFormatConsole is the aligned and colorized text format for interactive terminals
+	LogFormatConsole = "console"
+	// LogFormatSyslog is the syslog format, the protocol is specified by Config.Syslog
+	LogFormatSyslog = "syslog"
+	// LogFormatGELF is the graylog extended log format 1.1, the host is specified by Config.GELF
+	LogFormatGELF = "gelf"
+	// LogFormatCEF is the ArcSight common event format, the header is specified by Config.CEF
+	LogFormatCEF = "cef"
+	// LogFormatGlog is the glog and klog compatible format, the header is Lmmdd hh:mm:ss.uuuuuu threadid file:line]
+	LogFormatGlog = "glog"
+)
+
+const (
+	// EncoderKeyOmit omits the key from output
+	EncoderKeyOmit = "-"
+
+	DefaultTimeKey       = "time"
+	DefaultLevelKey      = "level"
+	DefaultNameKey       = "name"
+	DefaultCallerKey     = "caller"
+	DefaultFunctionKey   = EncoderKeyOmit
+	DefaultMessageKey    = "message"
+	DefaultStacktraceKey = "stack"
+
+	LevelEncoderCapital      = "capital"
+	LevelEncoderLower        = "lower"
+	LevelEncoderCapitalColor = "capital-color"
+	LevelEncoderColor        = "color"
+
+	DurationEncoderString  = "string"
+	DurationEncoderSeconds = "seconds"
+	DurationEncoderMillis  = "millis"
+	DurationEncoderNanos   = "nanos"
+
+	NameEncoderFull  = "full"
+	NameEncoderShort = "short"
+)
+
+var (
+	ErrNotSupportedByEncoder = "%s is not supported by %s encoder."
+	ErrInvalidTimeZone       = "invalid time zone %s, %s."
+)
+
+// Encoder is the interface that the encoders of this package implement,
+// besides zapcore.Encoder, it allows changing the settings of the encoder at runtime
+type Encoder interface {
+	zapcore.Encoder
+	// SetTimeFormat sets the time format to the encoder
+	SetTimeFormat(timeFormat string) error
+	// SetCallerEncoder sets the caller encoder to the encoder
+	SetCallerEncoder(callerEncoder string) error
+	// SetSeperator sets the seperator to the encoder
+	SetSeperator(seperator string) error
+	// SetDisableDoubleQuotes disables wrapping log content with double quotes
+	SetDisableDoubleQuotes(disableDoubleQuotes bool) error
+	// SetDisableEscape disables escaping special characters of log content like \n,\r...
+	SetDisableEscape(disableEscape bool) error
+
+	// addFields adds the fields to the encoder, it is used by textIOCore.With
+	addFields(fields []zapcore.Field)
+	// secretScanner returns the scanner of the encoder, nil means scanning is disabled
+	secretScanner() *secretScanner
+	// detachSettings gives the encoder its own copy of the shared settings,
+	// so the runtime setters of it do not reach the encoders it is cloned from
+	detachSettings()
+}
+
+// newZapEncoder returns an Encoder with the format specified in the config,
+// the output is used to decide whether the console format should be colorized,
+// unknown format falls back to text format
+func newZapEncoder(cfg *Config, output zapcore.WriteSyncer) (Encoder, error) {
+	err := cfg.Redact.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Scan.validate()
+	if err != nil {
+		return nil, err
+	}
+	err = validateCallerEncoder(cfg.Encoder.CallerEncoder)
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Syslog.validate()
+	if err != nil {
+		return nil, err
+	}
+	_, err = newTimeLocation(cfg.TimeZone)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(ErrInvalidTimeZone, cfg.TimeZone, err.Error()))
+	}
+	if cfg.Layout != "" {
+		_, err = parseLayout(cfg.Layout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch strings.ToLower(cfg.Format) {
+	case LogFormatJSON:
+		return NewJSONEncoder(cfg).(Encoder), nil
+	case LogFormatConsole:
+		return newConsoleEncoder(cfg, cfg.enableColor(output)), nil
+	case LogFormatSyslog:
+		return newSyslogEncoder(cfg), nil
+	case LogFormatGELF:
+		return newGELFEncoder(cfg), nil
+	case LogFormatCEF:
+		return newCEFEncoder(cfg), nil
+	case LogFormatGlog:
+		return newGlogEncoder(cfg), nil
+	default:
+		return newLayoutTextEncoder(cfg), nil
+	}
+}
+
+// newZapEncoderConfig returns the zapcore.EncoderConfig shared by the encoders of this package
+func newZapEncoderConfig(cfg *Config) zapcore.EncoderConfig {
+	ec := cfg.Encoder
+	cc := zapcore.EncoderConfig{
+		// Keys can be anything except the empty string.
+		TimeKey:        getEncoderKey(ec.TimeKey, DefaultTimeKey),
+		LevelKey:       getEncoderKey(ec.LevelKey, DefaultLevelKey),
+		NameKey:        getEncoderKey(ec.NameKey, DefaultNameKey),
+		CallerKey:      getEncoderKey(ec.CallerKey, DefaultCallerKey),
+		FunctionKey:    getEncoderKey(ec.FunctionKey, DefaultFunctionKey),
+		MessageKey:     getEncoderKey(ec.MessageKey, DefaultMessageKey),
+		StacktraceKey:  getEncoderKey(ec.StacktraceKey, DefaultStacktraceKey),
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    newLevelEncoder(ec.LevelEncoder),
+		EncodeTime:     DefaultTimeEncoder,
+		EncodeDuration: newDurationEncoder(ec.DurationEncoder),
+		EncodeCaller:   DefaultCallerEncoder,
+		EncodeName:     newNameEncoder(ec.NameEncoder),
+	}
+	if cfg.DisableTimestamp {
+		cc.TimeKey = ""
+	}
+
+	return cc
+}
+
+// getEncoderKey returns the key name used by zapcore.EncoderConfig,
+// empty key returns the default key, and EncoderKeyOmit returns an empty string which omits the key
+func getEncoderKey(key, defaultKey string) string {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		key = defaultKey
+	}
+	if key == EncoderKeyOmit {
+		return ""
+	}
+
+	return key
+}
+
+// newLevelEncoder returns the zapcore.LevelEncoder with given name, default is capital
+func newLevelEncoder(name string) zapcore.LevelEncoder {
+	switch strings.ToLower(name) {
+	case LevelEncoderLower, "lowercase":
+		return zapcore.LowercaseLevelEncoder
+	case LevelEncoderCapitalColor:
+		return CapitalColorLevelEncoder
+	case LevelEncoderColor:
+		return LowercaseColorLevelEncoder
+	default:
+		return zapcore.CapitalLevelEncoder
+	}
+}
+
+// newPlainLevelEncoder returns the zapcore.LevelEncoder with given name without color,
+// it is used if the level is colorized by the encoder itself, or the color codes would be nested
+func newPlainLevelEncoder(name string) zapcore.LevelEncoder {
+	switch strings.ToLower(name) {
+	case LevelEncoderCapitalColor:
+		return zapcore.CapitalLevelEncoder
+	case LevelEncoderColor:
+		return zapcore.LowercaseLevelEncoder
+	default:
+		return newLevelEncoder(name)
+	}
+}
+
+// newDurationEncoder returns the zapcore.DurationEncoder with given name, default is string
+func newDurationEncoder(name string) zapcore.DurationEncoder {
+	switch strings.ToLower(name) {
+	case DurationEncoderSeconds:
+		return zapcore.SecondsDurationEncoder
+	case DurationEncoderMillis:
+		return zapcore.MillisDurationEncoder
+	case DurationEncoderNanos:
+		return zapcore.NanosDurationEncoder
+	default:
+		return StringDurationEncoder
+	}
+}
+
+// StringDurationEncoder serializes a time.Duration using its String method like zapcore.StringDurationEncoder,
+// the duration is appended to the buffer of the text and json encoders directly without allocation
+func StringDurationEncoder(d time.Duration, enc zapcore.PrimitiveArrayEncoder) {
+	// the duration never needs quotes or escaping
+	switch e := enc.(type) {
+	case *textEncoder:
+		if e.scanner == nil && e.fieldLimiter() == nil {
+			e.addElementSeparator()
+			appendDuration(e.buf, d)
+			return
+		}
+	case *jsonEncoder:
+		if e.scanner == nil && e.fieldLimiter() == nil {
+			e.addElementSeparator()
+			e.buf.AppendByte('"')
+			appendDuration(e.buf, d)
+			e.buf.AppendByte('"')
+			return
+		}
+	}
+	enc.AppendString(d.String())
+}
+
+// appendDuration appends the duration in the format of time.Duration.String(), it is ported from the standard library
+func appendDuration(buf *buffer.Buffer, d time.Duration) {
+	// the largest duration is 2540400h10m10.000000000s
+	var arr [32]byte
+	w := len(arr)
+	u := uint64(d)
+	neg := d < 0
+	if neg {
+		u = -u
+	}
+
+	if u < uint64(time.Second) {
+		// the duration smaller than a second uses the smaller units, like 1.2ms
+		var prec int
+		w--
+		arr[w] = 's'
+		w--
+		switch {
+		case u == 0:
+			buf.AppendString("0s")
+			return
+		case u < uint64(time.Microsecond):
+			prec = 0
+			arr[w] = 'n'
+		case u < uint64(time.Millisecond):
+			prec = 3
+			// the micro sign needs two bytes
+			w--
+			copy(arr[w:], "µ")
+		default:
+			prec = 6
+			arr[w] = 'm'
+		}
+		w, u = fmtFrac(arr[:w], u, prec)
+		w = fmtInt(arr[:w], u)
+	} else {
+		w--
+		arr[w] = 's'
+		w, u = fmtFrac(arr[:w], u, 9)
+		// u is now integer seconds
+		w = fmtInt(arr[:w], u%60)
+		u /= 60
+		// u is now integer minutes
+		if u > 0 {
+			w--
+			arr[w] = 'm'
+			w = fmtInt(arr[:w], u%60)
+			u /= 60
+			// u is now integer hours
+			if u > 0 {
+				w--
+				arr[w] = 'h'
+				w = fmtInt(arr[:w], u)
+			}
+		}
+	}
+
+	if neg {
+		w--
+		arr[w] = '-'
+	}
+	_, _ = buf.Write(arr[w:])
+}
+
+// fmtFrac formats the fraction of v/10**prec into the tail of b, omitting the trailing zeros,
+// it returns the index where the output begins and v/10**prec
+func fmtFrac(b []byte, v uint64, prec int) (int, uint64) {
+	w := len(b)
+	print := false
+	for i := 0; i < prec; i++ {
+		digit := v % 10
+		print = print || digit != 0
+		if print {
+			w--
+			b[w] = byte(digit) + '0'
+		}
+		v /= 10
+	}
+	if print {
+		w--
+		b[w] = '.'
+	}
+
+	return w, v
+}
+
+// fmtInt formats v into the tail of b, it returns the index where the output begins
+func fmtInt(b []byte, v uint64) int {
+	w := len(b)
+	if v == 0 {
+		w--
+		b[w] = '0'
+		return w
+	}
+	for v > 0 {
+		w--
+		b[w] = byte(v%10) + '0'
+		v /= 10
+	}
+
+	return w
+}
+
+// newNameEncoder returns the zapcore.NameEncoder with given name, default is full
+func newNameEncoder(name string) zapcore.NameEncoder {
+	switch strings.ToLower(name) {
+	case NameEncoderShort:
+		return ShortNameEncoder
+	default:
+		return zapcore.FullNameEncoder
+	}
+}
+
+// CapitalColorLevelEncoder serializes a Level to an all-caps string with ANSI color,
+// the color is only added by the text encoders, other encoders get the plain level
+func CapitalColorLevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	appendColorLevel(l.CapitalString(), l, enc)
+}
+
+// LowercaseColorLevelEncoder serializes a Level to a lowercase string with ANSI color,
+// the color is only added by the text encoders, other encoders get the plain level
+func LowercaseColorLevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	appendColorLevel(l.String(), l, enc)
+}
+
+// appendColorLevel appends the level string with ANSI color if the encoder is a text encoder,
+// the color codes are appended directly, or they would be escaped
+func appendColorLevel(s string, l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	e, ok := enc.(*textEncoder)
+	if !ok {
+		enc.AppendString(s)
+		return
+	}
+
+	e.buf.AppendString(levelColor(l))
+	e.safeAddString(s)
+	e.buf.AppendString(colorReset)
+}
+
+// ShortNameEncoder serializes a logger name with the last element of the dotted name only
+func ShortNameEncoder(loggerName string, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(loggerName[strings.LastIndexByte(loggerName, '.')+1:])
+}
+
+// newErrNotSupportedByEncoder returns an error which indicates the setting is not supported by the encoder
+func newErrNotSupportedByEncoder(setting, format string) error {
+	return errors.New(fmt.Sprintf(ErrNotSupportedByEncoder, setting, format))
+}
@@ -0,0 +1,151 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// ErrorEncoderVerbose adds the error message and the verbose error, it is the default error encoder
+	ErrorEncoderVerbose = "verbose"
+	// ErrorEncoderChain adds the error message and the error chain, each cause of the chain is encoded
+	// with its message, type name and stack frames
+	ErrorEncoderChain = "chain"
+
+	errorChainSuffix = "Chain"
+	errorMessageKey  = "message"
+	errorTypeKey     = "type"
+	errorStackKey    = "stack"
+
+	// maxErrorChainLength caps the length of the error chain, in case of the errors unwrap to themselves
+	maxErrorChainLength = 64
+)
+
+// errorCause is an error of the error chain
+type errorCause struct {
+	message  string
+	typeName string
+	frames   stackFrames
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (c errorCause) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString(errorMessageKey, c.message)
+	enc.AddString(errorTypeKey, c.typeName)
+	if len(c.frames) > 0 {
+		return enc.AddArray(errorStackKey, c.frames)
+	}
+
+	return nil
+}
+
+// errorChain is the causes of an error, the first one is the error itself
+type errorChain []errorCause
+
+// MarshalLogArray implements zapcore.ArrayMarshaler
+func (chain errorChain) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, c := range chain {
+		err := enc.AppendObject(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lines returns each cause in type: message format, followed by the indented frames
+func (chain errorChain) lines() []string {
+	var lines []string
+	for _, c := range chain {
+		lines = append(lines, c.typeName+": "+c.message)
+		for _, line := range c.frames.lines() {
+			lines = append(lines, consoleIndent+line)
+		}
+	}
+
+	return lines
+}
+
+// newErrorChain walks the error chain by Unwrap() and Cause(), and the error lists of multierror,
+// the stack frames carried by github.com/pingcap/errors are filtered by the stack formatter if it is not nil
+func newErrorChain(err error, sf *stackFormatter) errorChain {
+	var chain errorChain
+	walkErrorChain(err, func(e error) bool {
+		chain = append(chain, errorCause{
+			message:  e.Error(),
+			typeName: fmt.Sprintf("%T", e),
+			frames:   getErrorStackFrames(e, sf),
+		})
+
+		return len(chain) < maxErrorChainLength
+	})
+
+	return chain
+}
+
+// walkErrorChain calls the visitor with the error and its causes in depth-first order,
+// the error lists are walked before unwrapping, it stops when the visitor returns false
+func walkErrorChain(err error, visitor func(err error) bool) bool {
+	if err == nil {
+		return true
+	}
+	if !visitor(err) {
+		return false
+	}
+
+	var errs []error
+	switch e := err.(type) {
+	case errors.ErrorGroup:
+		// github.com/romberli/go-multierror implements it
+		errs = e.Errors()
+	case interface{ Unwrap() []error }:
+		errs = e.Unwrap()
+	case interface{ Unwrap() error }:
+		errs = []error{e.Unwrap()}
+	case interface{ Cause() error }:
+		errs = []error{e.Cause()}
+	}
+	for _, e := range errs {
+		if !walkErrorChain(e, visitor) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// getErrorStackFrames returns the stack frames carried by the error itself, the causes are not included
+func getErrorStackFrames(err error, sf *stackFormatter) stackFrames {
+	st, ok := err.(errors.StackTracer)
+	if !ok {
+		return nil
+	}
+
+	trace := st.StackTrace()
+	if len(trace) == 0 {
+		return nil
+	}
+	pcs := make([]uintptr, len(trace))
+	for i, f := range trace {
+		pcs[i] = uintptr(f)
+	}
+
+	var frames stackFrames
+	callersFrames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := callersFrames.Next()
+		frames = append(frames, stackFrame{Function: frame.Function, File: frame.File, Line: frame.Line})
+		if !more {
+			break
+		}
+	}
+	if sf != nil {
+		frames = sf.filter(frames)
+	}
+
+	return frames
+}
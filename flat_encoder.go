@@ -0,0 +1,360 @@
+package log
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// paramWriter writes the params of a flat format, the params are the fields and the entry values
+// which are not a part of the header of the format
+type paramWriter interface {
+	// writeParam escapes the name and the value of the param with the rules of the format,
+	// and appends them to the buffer of the encoder, number is true if the value is a number
+	writeParam(enc *textEncoder, name, val string, number bool)
+}
+
+// flatEncoder is the zapcore.ObjectEncoder of the flat formats like syslog, gelf and cef,
+// the fields are added as name and value pairs by the param writer, the namespaces and the nested objects
+// are flattened into dotted names, and the arrays and the other values are encoded as they are in text format
+type flatEncoder struct {
+	*textEncoder
+	format string
+	writer paramWriter
+	// valueSettings are the settings of textValue(), the strings in the values are not quoted
+	valueSettings *sharedTextSettings
+}
+
+// newFlatEncoder returns a *flatEncoder with the text encoder of the config,
+// the values are always escaped, and the keys are dotted by the flat encoder itself
+func newFlatEncoder(cfg *Config, format string, writer paramWriter) *flatEncoder {
+	te := newTextEncoder(cfg, nil)
+	te.DisableDoubleQuotes = false
+	te.DisableEscape = false
+	te.dottedKeys = false
+	valueSettings := *te.textSettings
+	valueSettings.DisableDoubleQuotes = true
+
+	return &flatEncoder{
+		textEncoder:   te,
+		format:        format,
+		writer:        writer,
+		valueSettings: newSharedTextSettings(&valueSettings),
+	}
+}
+
+// clone returns a clone of the encoder with the buffer copied
+func (enc *flatEncoder) clone() *flatEncoder {
+	return &flatEncoder{
+		textEncoder:   enc.textEncoder.Clone().(*textEncoder),
+		format:        enc.format,
+		writer:        enc.writer,
+		valueSettings: enc.valueSettings,
+	}
+}
+
+// cloned returns a clone of the encoder with an empty buffer
+func (enc *flatEncoder) cloned() *flatEncoder {
+	return &flatEncoder{
+		textEncoder:   enc.textEncoder.cloned(),
+		format:        enc.format,
+		writer:        enc.writer,
+		valueSettings: enc.valueSettings,
+	}
+}
+
+// free puts the text encoder and its buffer back to the pools
+func (enc *flatEncoder) free() {
+	enc.buf.Free()
+	putTextEncoder(enc.textEncoder)
+}
+
+// SetTimeFormat returns an error, as the time format is defined by the format
+func (enc *flatEncoder) SetTimeFormat(timeFormat string) error {
+	return newErrNotSupportedByEncoder("time format", enc.format)
+}
+
+// detachSettings gives the encoder its own copies of the shared settings and the settings of the values
+func (enc *flatEncoder) detachSettings() {
+	enc.textEncoder.detachSettings()
+	enc.valueSettings = newSharedTextSettings(enc.valueSettings.load())
+}
+
+// SetCallerEncoder sets the caller encoder to the encoder and to the settings of the values
+func (enc *flatEncoder) SetCallerEncoder(callerEncoder string) error {
+	err := enc.textEncoder.SetCallerEncoder(callerEncoder)
+	if err != nil {
+		return err
+	}
+
+	enc.valueSettings.update(func(settings *textSettings) {
+		settings.callerEncoder = strings.ToLower(callerEncoder)
+	})
+	return nil
+}
+
+// SetSeperator returns an error, as the flat formats have no seperator
+func (enc *flatEncoder) SetSeperator(seperator string) error {
+	return newErrNotSupportedByEncoder("seperator", enc.format)
+}
+
+// SetDisableDoubleQuotes returns an error, as the quotes are defined by the format
+func (enc *flatEncoder) SetDisableDoubleQuotes(disableDoubleQuotes bool) error {
+	return newErrNotSupportedByEncoder("disabling double quotes", enc.format)
+}
+
+// SetDisableEscape returns an error, as the values must be escaped with the rules of the format
+func (enc *flatEncoder) SetDisableEscape(disableEscape bool) error {
+	return newErrNotSupportedByEncoder("disabling escape", enc.format)
+}
+
+func (enc *flatEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return nil
+	}
+	var err error
+	enc.addParam(key, enc.textValue(func(te *textEncoder) {
+		err = te.marshalArray(arr)
+	}))
+	return err
+}
+
+func (enc *flatEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return nil
+	}
+	// the fields of the object are added with the key as the prefix
+	namespace := enc.namespace
+	enc.namespace += key + "."
+	err := obj.MarshalLogObject(enc)
+	enc.namespace = namespace
+	return err
+}
+
+func (enc *flatEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *flatEncoder) AddByteString(key string, val []byte) {
+	enc.AddString(key, string(val))
+}
+
+func (enc *flatEncoder) AddBool(key string, val bool) {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return
+	}
+	enc.addParam(key, strconv.FormatBool(val))
+}
+
+func (enc *flatEncoder) AddComplex128(key string, val complex128) {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return
+	}
+	enc.addParam(key, enc.textValue(func(te *textEncoder) {
+		te.AppendComplex128(val)
+	}))
+}
+
+func (enc *flatEncoder) AddDuration(key string, val time.Duration) {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return
+	}
+	enc.addParam(key, enc.textValue(func(te *textEncoder) {
+		te.AppendDuration(val)
+	}))
+}
+
+func (enc *flatEncoder) AddFloat64(key string, val float64) {
+	enc.addRedactableParam(key, enc.textValue(func(te *textEncoder) {
+		te.AppendFloat64(val)
+	}), !math.IsNaN(val) && !math.IsInf(val, 0))
+}
+
+func (enc *flatEncoder) AddInt64(key string, val int64) {
+	enc.addRedactableParam(key, strconv.FormatInt(val, 10), true)
+}
+
+func (enc *flatEncoder) AddReflected(key string, obj interface{}) error {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return nil
+	}
+	if encode := getTypeEncoder(obj, enc.useTextMarshaler, enc.useStringer); encode != nil {
+		enc.addParam(key, enc.textValue(func(te *textEncoder) {
+			encode(obj, te)
+		}))
+		return nil
+	}
+	enc.resetReflectBuf()
+	err := enc.reflectEnc.Encode(obj)
+	if err != nil {
+		return err
+	}
+	enc.reflectBuf.TrimNewline()
+	enc.addParam(key, enc.limiter.truncateField(enc.reflectBuf.String()))
+	return nil
+}
+
+func (enc *flatEncoder) OpenNamespace(key string) {
+	if enc.isRedacted(key) {
+		enc.redactAll = true
+	}
+	enc.namespace += key + "."
+}
+
+func (enc *flatEncoder) AddString(key, val string) {
+	enc.addRedactableParam(key, enc.limiter.truncateField(val), false)
+}
+
+func (enc *flatEncoder) AddTime(key string, val time.Time) {
+	if enc.isRedacted(key) {
+		enc.addMaskedParam(key)
+		return
+	}
+	enc.addParam(key, enc.textValue(func(te *textEncoder) {
+		te.AppendTime(val)
+	}))
+}
+
+func (enc *flatEncoder) AddUint64(key string, val uint64) {
+	enc.addRedactableParam(key, strconv.FormatUint(val, 10), true)
+}
+
+func (enc *flatEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *flatEncoder) AddFloat32(k string, v float32)     { enc.AddFloat64(k, float64(v)) }
+func (enc *flatEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *flatEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *flatEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *flatEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *flatEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *flatEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *flatEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *flatEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *flatEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+
+// stack returns the stack of the entry, which is filtered by the stack formatter,
+// it returns an empty string if the stack should not be added
+func (enc *flatEncoder) stack(ent zapcore.Entry) string {
+	if ent.Stack == "" || enc.StacktraceKey == "" {
+		return ""
+	}
+	if enc.stackFormatter != nil {
+		frames, ok := enc.stackFormatter.frames(ent.Stack)
+		if ok {
+			return frames.String()
+		}
+	}
+
+	return ent.Stack
+}
+
+// addFields adds the fields to the encoder, it is used by textIOCore.With
+func (enc *flatEncoder) addFields(fields []zapcore.Field) {
+	for _, f := range fields {
+		if f.Type == zapcore.ErrorType {
+			enc.encodeError(f)
+			continue
+		}
+		f.AddTo(enc)
+	}
+}
+
+// encodeError adds the error message, and the verbose error or the error chain of the field
+func (enc *flatEncoder) encodeError(f zapcore.Field) {
+	err := f.Interface.(error)
+	basic := err.Error()
+	enc.AddString(f.Key, basic)
+	if enc.disableErrorVerbose || enc.isRedacted(f.Key) {
+		return
+	}
+	if enc.errorEncoder == ErrorEncoderChain {
+		_ = enc.AddArray(f.Key+errorChainSuffix, newErrorChain(err, enc.stackFormatter))
+		return
+	}
+	if e, isFormatter := err.(fmt.Formatter); isFormatter {
+		verbose := fmt.Sprintf("%+v", e)
+		if verbose != basic {
+			enc.AddString(f.Key+"Verbose", verbose)
+		}
+	}
+}
+
+// caller returns the caller encoded by the caller encoder
+func (enc *flatEncoder) caller(ec zapcore.EntryCaller) string {
+	return enc.textValue(func(te *textEncoder) {
+		te.noLimit = true
+		te.EncodeCaller(ec, te)
+	})
+}
+
+// textValue returns the value encoded by a text encoder, the strings in the value are not quoted,
+// as the value will be quoted as a whole
+func (enc *flatEncoder) textValue(appendValue func(te *textEncoder)) string {
+	te := enc.textEncoder.nested()
+	te.textSettings = enc.valueSettings.load()
+	appendValue(te)
+	val := te.buf.String()
+	te.buf.Free()
+	putTextEncoder(te)
+
+	return val
+}
+
+// addRedactableParam adds the param, the value is redacted if the key should be redacted,
+// number is true if the value is a number, the redacted value is never a number
+func (enc *flatEncoder) addRedactableParam(key, val string, number bool) {
+	if enc.isRedacted(key) {
+		enc.writeParam(enc.namespace+key, enc.redactor.redact(val), false)
+		return
+	}
+	enc.writeParam(enc.namespace+key, val, number)
+}
+
+// addMaskedParam adds the param with the mask, it is used for the values which could not be hashed
+func (enc *flatEncoder) addMaskedParam(key string) {
+	enc.addParam(key, enc.redactor.mask)
+}
+
+// addParam adds the param with the string value, the name is prefixed with the namespace
+func (enc *flatEncoder) addParam(key, val string) {
+	enc.writeParam(enc.namespace+key, val, false)
+}
+
+// writeParam scans the secrets in the string value, and writes the param by the param writer
+func (enc *flatEncoder) writeParam(name, val string, number bool) {
+	if !number && enc.scanner != nil {
+		val = enc.scanner.scan(val)
+	}
+	enc.writer.writeParam(enc.textEncoder, name, val, number)
+}
+
+// getHostname returns the host name, empty host returns the host name reported by the kernel
+func getHostname(host string) string {
+	if host != "" {
+		return host
+	}
+	host, _ = os.Hostname()
+
+	return host
+}
+
+// getAppName returns the app name, empty name returns the base name of the executable
+func getAppName(name string) string {
+	if name != "" {
+		return name
+	}
+
+	return filepath.Base(os.Args[0])
+}
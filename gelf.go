@@ -0,0 +1,164 @@
+package log
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	gelfVersion = "1.1"
+	// gelfIDName is the name of the additional field _id, which is not allowed by gelf, so it is renamed
+	gelfIDName = "_id"
+)
+
+// GELFConfig serializes gelf format related config in yaml/json.
+type GELFConfig struct {
+	// Host is the host of the messages, default is the host name reported by the kernel.
+	Host string `yaml:"host" json:"host"`
+}
+
+// gelfHeader is the static part of the gelf messages, it is shared by the clones of the encoder
+type gelfHeader struct {
+	host string
+}
+
+// writeParam writes the param as an additional field, the name is prefixed with an underscore,
+// the numbers are written as json numbers, and the other values are written as json strings
+func (h *gelfHeader) writeParam(enc *textEncoder, name, val string, number bool) {
+	if name == "id" {
+		name = gelfIDName
+	}
+	enc.buf.AppendString(`,"_`)
+	enc.buf.AppendString(sanitizeGELFName(name))
+	enc.buf.AppendString(`":`)
+	if number {
+		enc.buf.AppendString(val)
+		return
+	}
+	enc.buf.AppendByte('"')
+	enc.escapeString(val)
+	enc.buf.AppendByte('"')
+}
+
+// gelfEncoder encodes the entries in gelf 1.1 format, the level is mapped to the syslog severity,
+// the stack is added to full_message, and the fields are added as the additional fields,
+// the namespaces and the nested objects are flattened into dotted names
+type gelfEncoder struct {
+	*flatEncoder
+	header *gelfHeader
+}
+
+// NewGELFEncoder creates a gelf encoder, the host is specified by Config.GELF
+func NewGELFEncoder(cfg *Config) zapcore.Encoder {
+	return newGELFEncoder(cfg)
+}
+
+// newGELFEncoder returns a *gelfEncoder
+func newGELFEncoder(cfg *Config) *gelfEncoder {
+	header := &gelfHeader{host: getHostname(cfg.GELF.Host)}
+
+	return &gelfEncoder{
+		flatEncoder: newFlatEncoder(cfg, LogFormatGELF, header),
+		header:      header,
+	}
+}
+
+func (enc *gelfEncoder) Clone() zapcore.Encoder {
+	return &gelfEncoder{
+		flatEncoder: enc.flatEncoder.clone(),
+		header:      enc.header,
+	}
+}
+
+func (enc *gelfEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	params := enc.cloned()
+	if ent.LoggerName != "" && params.NameKey != "" {
+		params.writeParam(params.NameKey, ent.LoggerName, false)
+	}
+	if ent.Caller.Defined && params.CallerKey != "" {
+		params.writeParam(params.CallerKey, params.caller(ent.Caller), false)
+	}
+	if ent.Caller.Defined && params.FunctionKey != "" {
+		params.writeParam(params.FunctionKey, ent.Caller.Function, false)
+	}
+	_, _ = params.buf.Write(enc.buf.Bytes())
+	params.addFields(fields)
+
+	final := enc.textEncoder.cloned()
+	msg := final.limiter.truncateMessage(ent.Message)
+	enc.encodeEntry(final, ent, msg, params.buf.Bytes(), params.stack(ent), 0)
+	if final.limiter.entryExceeded(final.buf.Len()) {
+		// drop the additional fields and the stack, and truncate the message if it is still too large,
+		// so that the entry is always a complete json object
+		atomic.AddInt64(&_truncationEntry, 1)
+		size := final.buf.Len()
+		final.limiter.fitMessage(ent.Message, msg, func(msg string) int {
+			final.buf.Reset()
+			enc.encodeEntry(final, ent, msg, nil, "", size)
+			return final.buf.Len()
+		})
+	}
+	params.free()
+
+	ret := final.buf
+	putTextEncoder(final)
+	return ret, nil
+}
+
+// encodeEntry encodes the entry to the buffer of final, msg is the message which has been truncated by the limiter,
+// params are the encoded additional fields, if size is larger than 0, the truncated marker is added instead of the params,
+// size is the size of the entry before dropping
+func (enc *gelfEncoder) encodeEntry(final *textEncoder, ent zapcore.Entry, msg string, params []byte, stack string, size int) {
+	final.buf.AppendString(`{"version":"`)
+	final.buf.AppendString(gelfVersion)
+	final.buf.AppendString(`","host":"`)
+	final.escapeString(enc.header.host)
+	final.buf.AppendString(`","short_message":"`)
+	final.safeAddString(msg)
+	final.buf.AppendByte('"')
+	if stack != "" {
+		final.buf.AppendString(`,"full_message":"`)
+		final.safeAddString(msg + "\n" + stack)
+		final.buf.AppendByte('"')
+	}
+	if final.TimeKey != "" {
+		final.buf.AppendString(`,"timestamp":`)
+		appendGELFTimestamp(final.buf, ent.Time)
+	}
+	final.buf.AppendString(`,"level":`)
+	final.buf.AppendInt(int64(syslogSeverity(ent.Level)))
+	if size > 0 {
+		enc.header.writeParam(final, DefaultTruncatedKey, truncatedMarker(size-final.buf.Len(), truncatedMarkerBytes), false)
+	} else {
+		_, _ = final.buf.Write(params)
+	}
+	final.buf.AppendByte('}')
+	final.buf.AppendString(final.lineEnding())
+}
+
+// appendGELFTimestamp appends the seconds since the unix epoch with microseconds as the decimal part
+func appendGELFTimestamp(buf *buffer.Buffer, t time.Time) {
+	buf.AppendInt(t.Unix())
+	buf.AppendByte('.')
+	micros := strconv.Itoa(t.Nanosecond() / int(time.Microsecond))
+	for i := len(micros); i < 6; i++ {
+		buf.AppendByte('0')
+	}
+	buf.AppendString(micros)
+}
+
+// sanitizeGELFName replaces the characters which are not allowed in the names of the additional fields
+// with underscores, the allowed characters are letters, digits, underscores, dashes and dots
+func sanitizeGELFName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -25,6 +25,9 @@ const (
 var (
 	_globalL, _globalP, _ = NewLogger()
 	_globalS              = _globalL.SugaredLogger
+	// _globalV is the logger of V(), the global logger skips one more caller for the global functions,
+	// but Verbose calls zap directly
+	_globalV = _globalL.WithOptions(zap.AddCallerSkip(-1))
 )
 
 // L returns the global Logger, which can be reconfigured with ReplaceGlobals.
@@ -49,36 +52,125 @@ func P() *ZapProperties {
 func ReplaceGlobals(logger *Logger, props *ZapProperties) {
 	_globalL = logger.WithOptions(zap.AddCallerSkip(DefaultCallerSkip))
 	_globalS = logger.Sugar()
+	_globalV = logger
 	_globalP = props
 }
 
 // SetTimeFormat sets the time format of global logger
-func SetTimeFormat(timeFormat string) {
-	_globalL.SetTimeFormat(timeFormat)
-	_globalP.Core.(*textIOCore).SetTimeFormat(timeFormat)
+func SetTimeFormat(timeFormat string) error {
+	err := _globalL.SetTimeFormat(timeFormat)
+	if err != nil {
+		return err
+	}
+
+	core, err := getTextIOCore(_globalP.Core)
+	if err != nil {
+		return err
+	}
+
+	return core.SetTimeFormat(timeFormat)
+}
+
+// SetCallerEncoder sets the caller encoder of global logger
+func SetCallerEncoder(callerEncoder string) error {
+	err := _globalL.SetCallerEncoder(callerEncoder)
+	if err != nil {
+		return err
+	}
+
+	core, err := getTextIOCore(_globalP.Core)
+	if err != nil {
+		return err
+	}
+
+	return core.SetCallerEncoder(callerEncoder)
 }
 
 // SetSeperator sets the seperator of global logger
-func SetSeperator(seperator string) {
-	_globalL.SetSeperator(seperator)
-	_globalP.Core.(*textIOCore).SetSeperator(seperator)
+func SetSeperator(seperator string) error {
+	err := _globalL.SetSeperator(seperator)
+	if err != nil {
+		return err
+	}
+
+	core, err := getTextIOCore(_globalP.Core)
+	if err != nil {
+		return err
+	}
+
+	return core.SetSeperator(seperator)
 }
 
 // SetDisableDoubleQuotes disables wrapping log content with double quotes of global logger
-func SetDisableDoubleQuotes(disableDoubleQuotes bool) {
-	_globalL.SetDisableDoubleQuotes(disableDoubleQuotes)
-	_globalP.Core.(*textIOCore).SetDisableDoubleQuotes(disableDoubleQuotes)
+func SetDisableDoubleQuotes(disableDoubleQuotes bool) error {
+	err := _globalL.SetDisableDoubleQuotes(disableDoubleQuotes)
+	if err != nil {
+		return err
+	}
+
+	core, err := getTextIOCore(_globalP.Core)
+	if err != nil {
+		return err
+	}
+
+	return core.SetDisableDoubleQuotes(disableDoubleQuotes)
 }
 
 // SetDisableEscape disables wrapping log content with double quotes of global logger
-func SetDisableEscape(disableEscape bool) {
-	_globalL.SetDisableEscape(disableEscape)
-	_globalP.Core.(*textIOCore).SetDisableEscape(disableEscape)
+func SetDisableEscape(disableEscape bool) error {
+	err := _globalL.SetDisableEscape(disableEscape)
+	if err != nil {
+		return err
+	}
+
+	core, err := getTextIOCore(_globalP.Core)
+	if err != nil {
+		return err
+	}
+
+	return core.SetDisableEscape(disableEscape)
 }
 
 // AddWriteSyncer add write syncer to multi write syncer, which allows to add a new way to write log message
-func AddWriteSyncer(ws zapcore.WriteSyncer) {
-	_globalL.AddWriteSyncer(ws)
+func AddWriteSyncer(ws zapcore.WriteSyncer) error {
+	return _globalL.AddWriteSyncer(ws)
+}
+
+// Sync flushes the buffered log entries of global logger, including the summaries of the suppressed duplicates
+func Sync() error {
+	return _globalL.Sync()
+}
+
+// Close stops the report goroutine of the sampler of global logger and syncs it
+func Close() error {
+	return _globalL.Close()
+}
+
+// GetSamplingStats returns the numbers of the sampled and the dropped entries of the levels of global logger
+func GetSamplingStats() (SamplingStats, error) {
+	return _globalL.GetSamplingStats()
+}
+
+// GetSecretHits returns the numbers of masked secrets of each detector of global logger
+func GetSecretHits() (map[string]int64, error) {
+	return _globalL.GetSecretHits()
+}
+
+// ResetSecretHits resets the numbers of masked secrets of global logger to zero
+func ResetSecretHits() error {
+	return _globalL.ResetSecretHits()
+}
+
+// AddHook adds a hook to global logger, which is called with each entry of the levels,
+// empty levels means all the levels
+func AddHook(hook Hook, levels ...zapcore.Level) error {
+	return _globalL.AddHook(hook, levels...)
+}
+
+// AddWriteSyncerWithConfig adds an output to global logger, which writes log message to the write syncer
+// with its own level, format and encoder options of the config
+func AddWriteSyncerWithConfig(ws zapcore.WriteSyncer, cfg *Config) error {
+	return _globalL.AddWriteSyncerWithConfig(ws, cfg)
 }
 
 // Clone clones global logger
@@ -89,7 +181,7 @@ func Clone() *Logger {
 // CloneAndAddWriteSyncer clones global logger and add specified write syncer to it
 func CloneAndAddWriteSyncer(ws zapcore.WriteSyncer) *Logger {
 	c := Clone()
-	c.AddWriteSyncer(ws)
+	_ = c.AddWriteSyncer(ws)
 	return c
 }
 
@@ -98,6 +190,18 @@ func CloneStdoutLogger() *Logger {
 	return CloneAndAddWriteSyncer(NewStdoutWriteSyncer())
 }
 
+// CloneWithWriteSyncer clones global logger and add specified write syncer to it,
+// an error is returned if the write syncer could not be added
+func CloneWithWriteSyncer(ws zapcore.WriteSyncer) (*Logger, error) {
+	c := Clone()
+	err := c.AddWriteSyncer(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 // Rotate rotates the log file of global logger
 func Rotate() error {
 	return L().Rotate()
@@ -174,6 +278,12 @@ func Fatalf(template string, args ...interface{}) {
 	S().Fatalf(template, args...)
 }
 
+// V returns a Verbose of the global logger which logs at InfoLevel only if level is not larger than the verbosity threshold,
+// for example: log.V(2).Info("message")
+func V(level int) Verbose {
+	return newVerbose(_globalV, level)
+}
+
 // With creates a child logger and adds structured context to it.
 // Fields added to the child don't affect the parent, and vice versa.
 func With(fields ...zap.Field) *zap.Logger {
@@ -0,0 +1,110 @@
+package log
+
+import (
+	"fmt"
+	"os"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	glogTimeFormat = "0102 15:04:05.000000"
+	// glogUnknownCaller is the caller of glog if the caller is not defined
+	glogUnknownCaller = "???:1"
+)
+
+// glogLevel returns the severity letter of glog, glog has no debug and panic levels,
+// so debug is mapped to info, and dpanic and panic are mapped to error
+func glogLevel(level zapcore.Level) byte {
+	switch level {
+	case zapcore.WarnLevel:
+		return 'W'
+	case zapcore.ErrorLevel, zapcore.DPanicLevel, zapcore.PanicLevel:
+		return 'E'
+	case zapcore.FatalLevel:
+		return 'F'
+	default:
+		return 'I'
+	}
+}
+
+// glogEncoder is a textEncoder with the header of glog and klog, which is Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg,
+// the thread id is the process id as klog does, the fields are added after the message in text format,
+// and the stack traces are added as indented lines after the log line
+type glogEncoder struct {
+	*textEncoder
+	// pid is the process id padded to 7 characters
+	pid string
+}
+
+// NewGlogEncoder creates a glog encoder
+func NewGlogEncoder(cfg *Config) zapcore.Encoder {
+	return newGlogEncoder(cfg)
+}
+
+// newGlogEncoder returns a *glogEncoder
+func newGlogEncoder(cfg *Config) *glogEncoder {
+	return &glogEncoder{
+		textEncoder: newTextEncoder(cfg, nil),
+		pid:         fmt.Sprintf("%7d", os.Getpid()),
+	}
+}
+
+// SetTimeFormat returns an error, as the time format is defined by the glog header
+func (enc *glogEncoder) SetTimeFormat(timeFormat string) error {
+	return newErrNotSupportedByEncoder("time format", LogFormatGlog)
+}
+
+// SetSeperator returns an error, as the message always follows the header
+func (enc *glogEncoder) SetSeperator(seperator string) error {
+	return newErrNotSupportedByEncoder("seperator", LogFormatGlog)
+}
+
+func (enc *glogEncoder) Clone() zapcore.Encoder {
+	return &glogEncoder{
+		textEncoder: enc.textEncoder.Clone().(*textEncoder),
+		pid:         enc.pid,
+	}
+}
+
+func (enc *glogEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := enc.textEncoder.cloned()
+	final.buf.AppendByte(glogLevel(ent.Level))
+	t := ent.Time
+	if final.timeLocation != nil {
+		t = t.In(final.timeLocation)
+	}
+	final.buf.AppendTime(t, glogTimeFormat)
+	final.buf.AppendByte(' ')
+	final.buf.AppendString(enc.pid)
+	final.buf.AppendByte(' ')
+	cur := final.buf.Len()
+	if ent.Caller.Defined {
+		final.noLimit = true
+		final.EncodeCaller(ent.Caller, final)
+		final.noLimit = false
+	}
+	if cur == final.buf.Len() {
+		final.buf.AppendString(glogUnknownCaller)
+	}
+	final.buf.AppendString("] ")
+	final.safeAddString(final.limiter.truncateMessage(ent.Message))
+	if enc.buf.Len() > 0 || len(fields) > 0 {
+		final.buf.AppendByte(' ')
+		_, _ = final.buf.Write(enc.buf.Bytes())
+	}
+	final.addFields(fields)
+	final.closeOpenNamespaces()
+
+	lineEnding := final.lineEnding()
+	final.buf.AppendString(lineEnding)
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		appendIndentedBlock(final.buf, final.consoleStack(ent.Stack), lineEnding)
+	}
+	final.truncateEntry()
+
+	ret := final.buf
+	putTextEncoder(final)
+	return ret, nil
+}
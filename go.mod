@@ -9,5 +9,5 @@ require (
 	github.com/sirupsen/logrus v1.9.3
 	github.com/stretchr/testify v1.9.0
 	go.uber.org/zap v1.27.0
-	golang.org/x/sys v0.24.0 // indirect
+	golang.org/x/sys v0.24.0
 )
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"github.com/romberli/go-multierror"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	ErrInvalidHookLevel = "invalid level %s of hook, valid levels are from %s to %s."
+)
+
+// Hook is called with each entry written by the core, the fields include the fields added by With(),
+// the error is reported to the error output of the logger
+type Hook func(ent zapcore.Entry, fields []zapcore.Field) error
+
+// levelHook is a hook which is only called with the entries of its levels
+type levelHook struct {
+	hook Hook
+	// levels is the bitmap of the levels, zero means all the levels
+	levels uint64
+}
+
+// enabled returns true if the hook should be called with the level
+func (h levelHook) enabled(level zapcore.Level) bool {
+	return h.levels == 0 || h.levels&levelBit(level) != 0
+}
+
+// levelBit returns the bit of the level in the bitmap, the level must be valid, see validateHookLevel()
+func levelBit(level zapcore.Level) uint64 {
+	return 1 << uint(level-zapcore.DebugLevel)
+}
+
+// hooks holds the hooks of a core, it is shared by the clones of the core,
+// so the hooks added after With() reach the child loggers, the list is a snapshot like sharedTextSettings
+type hooks struct {
+	snapshot
+}
+
+// newHooks returns a *hooks without any hook
+func newHooks() *hooks {
+	h := &hooks{}
+	h.store([]levelHook(nil))
+
+	return h
+}
+
+// load returns the hooks, they must not be changed
+func (h *hooks) load() []levelHook {
+	return h.snapshot.load().([]levelHook)
+}
+
+// validateHookLevel returns an error if the level is not in [DebugLevel, FatalLevel]
+func validateHookLevel(level zapcore.Level) error {
+	if level < zapcore.DebugLevel || level > zapcore.FatalLevel {
+		return errors.New(fmt.Sprintf(ErrInvalidHookLevel, level.String(), zapcore.DebugLevel.String(), zapcore.FatalLevel.String()))
+	}
+
+	return nil
+}
+
+// copied returns a new *hooks with the same hooks, the hooks added to it later are not called by h
+func (h *hooks) copied() *hooks {
+	cp := &hooks{}
+	cp.store(h.load())
+
+	return cp
+}
+
+// add appends the hook with the levels to a copy of the list and swaps it in, empty levels means all the levels,
+// an error is returned if any of the levels is not valid
+func (h *hooks) add(hook Hook, levels ...zapcore.Level) error {
+	lh := levelHook{hook: hook}
+	for _, level := range levels {
+		err := validateHookLevel(level)
+		if err != nil {
+			return err
+		}
+		lh.levels |= levelBit(level)
+	}
+
+	h.update(func(value interface{}) interface{} {
+		old := value.([]levelHook)
+		list := make([]levelHook, len(old), len(old)+1)
+		copy(list, old)
+		return append(list, lh)
+	})
+
+	return nil
+}
+
+// run calls the hooks of the level with the entry and the fields, the errors of the hooks are combined
+func (h *hooks) run(ent zapcore.Entry, fields []zapcore.Field) error {
+	var err *multierror.Error
+	for _, lh := range h.load() {
+		if lh.enabled(ent.Level) {
+			err = multierror.Append(err, lh.hook(ent, fields))
+		}
+	}
+
+	return err.ErrorOrNil()
+}
@@ -0,0 +1,289 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// ExampleLayout is an example of the pattern layout of text format
+	ExampleLayout = "%time %-5level [%caller] %msg %fields"
+)
+
+const (
+	layoutTokenLiteral layoutTokenKind = iota
+	layoutTokenTime
+	layoutTokenLevel
+	layoutTokenCaller
+	layoutTokenFunction
+	layoutTokenName
+	layoutTokenSeperator
+	layoutTokenMessage
+	layoutTokenFields
+)
+
+var (
+	ErrInvalidLayout = "invalid layout %s, %s."
+
+	// layoutTokenNames maps the token names to the kinds of token,
+	// the single letters are the conversion characters of log4j
+	layoutTokenNames = map[string]layoutTokenKind{
+		"time":     layoutTokenTime,
+		"d":        layoutTokenTime,
+		"level":    layoutTokenLevel,
+		"p":        layoutTokenLevel,
+		"caller":   layoutTokenCaller,
+		"l":        layoutTokenCaller,
+		"function": layoutTokenFunction,
+		"func":     layoutTokenFunction,
+		"M":        layoutTokenFunction,
+		"name":     layoutTokenName,
+		"c":        layoutTokenName,
+		"sep":      layoutTokenSeperator,
+		"msg":      layoutTokenMessage,
+		"message":  layoutTokenMessage,
+		"m":        layoutTokenMessage,
+		"fields":   layoutTokenFields,
+	}
+)
+
+type layoutTokenKind int
+
+// layoutToken is a parsed token of the pattern layout
+type layoutToken struct {
+	kind    layoutTokenKind
+	literal string
+	// width is the minimum width of the token, it pads spaces on the left,
+	// or on the right if leftAlign is true
+	width     int
+	leftAlign bool
+	// maxWidth truncates the token if it is larger than 0
+	maxWidth int
+	// implicit is true if the token is appended by parseLayout() instead of the layout,
+	// it is separated by a space only if it is not empty
+	implicit bool
+}
+
+// quoted returns true if the token could be quoted and escaped, these tokens are truncated
+// before being quoted and escaped, so that the quotes and the escape sequences are never cut
+func (token layoutToken) quoted() bool {
+	switch token.kind {
+	case layoutTokenCaller, layoutTokenFunction, layoutTokenName, layoutTokenMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// parseLayout parses the pattern layout, the syntax of a token is %[-][width][.maxWidth]name,
+// for example: %-5level pads the level to 5 characters on the right, %.20caller keeps 20 characters of the caller,
+// %% is a literal %, the fields and the stack are appended after a space if the layout does not contain %fields
+func parseLayout(layout string) ([]layoutToken, error) {
+	var (
+		tokens    []layoutToken
+		hasFields bool
+	)
+
+	literal := make([]byte, 0, len(layout))
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			literal = append(literal, layout[i])
+			continue
+		}
+		i++
+		if i < len(layout) && layout[i] == '%' {
+			literal = append(literal, '%')
+			continue
+		}
+		if len(literal) > 0 {
+			tokens = append(tokens, layoutToken{kind: layoutTokenLiteral, literal: string(literal)})
+			literal = literal[:0]
+		}
+
+		token := layoutToken{}
+		if i < len(layout) && layout[i] == '-' {
+			token.leftAlign = true
+			i++
+		}
+		start := i
+		for i < len(layout) && layout[i] >= '0' && layout[i] <= '9' {
+			i++
+		}
+		if i > start {
+			token.width, _ = strconv.Atoi(layout[start:i])
+		}
+		if i < len(layout) && layout[i] == '.' {
+			i++
+			start = i
+			for i < len(layout) && layout[i] >= '0' && layout[i] <= '9' {
+				i++
+			}
+			if i == start {
+				return nil, errors.New(fmt.Sprintf(ErrInvalidLayout, layout, "missing max width after ."))
+			}
+			token.maxWidth, _ = strconv.Atoi(layout[start:i])
+		}
+		start = i
+		for i < len(layout) && (layout[i] >= 'a' && layout[i] <= 'z' || layout[i] >= 'A' && layout[i] <= 'Z') {
+			i++
+		}
+		name := layout[start:i]
+		kind, ok := layoutTokenNames[name]
+		if !ok {
+			return nil, errors.New(fmt.Sprintf(ErrInvalidLayout, layout, "unknown token %"+name))
+		}
+		token.kind = kind
+		hasFields = hasFields || kind == layoutTokenFields
+		tokens = append(tokens, token)
+		// the loop increases i
+		i--
+	}
+	if len(literal) > 0 {
+		tokens = append(tokens, layoutToken{kind: layoutTokenLiteral, literal: string(literal)})
+	}
+	if !hasFields {
+		tokens = append(tokens, layoutToken{kind: layoutTokenFields, implicit: true})
+	}
+
+	return tokens, nil
+}
+
+// encodeLayoutEntry encodes the entry with the pattern layout, enc is the encoder with the fields added by With()
+func (final *textEncoder) encodeLayoutEntry(enc *textEncoder, ent zapcore.Entry, fields []zapcore.Field) {
+	// each token is encoded to a separate buffer, so that it could be padded and truncated,
+	// and the element separator will not be added after the literals
+	orig := final.buf
+	tokenBuf := _pool.Get()
+	for _, token := range final.layout {
+		if token.kind == layoutTokenLiteral {
+			orig.AppendString(token.literal)
+			continue
+		}
+
+		tokenBuf.Reset()
+		final.buf = tokenBuf
+		final.noLimit = token.kind != layoutTokenMessage && token.kind != layoutTokenFields
+		final.encodeLayoutToken(token, enc, ent, fields)
+		final.noLimit = false
+		if token.implicit && tokenBuf.Len() > 0 {
+			orig.AppendByte(' ')
+		}
+		appendLayoutToken(orig, tokenBuf.Bytes(), token)
+	}
+	final.buf = orig
+	tokenBuf.Free()
+}
+
+// encodeLayoutToken encodes the token to the buffer
+func (final *textEncoder) encodeLayoutToken(token layoutToken, enc *textEncoder, ent zapcore.Entry, fields []zapcore.Field) {
+	switch token.kind {
+	case layoutTokenTime:
+		if final.TimeKey != "" {
+			final.AppendTime(ent.Time)
+		}
+	case layoutTokenLevel:
+		if final.LevelKey != "" {
+			cur := final.buf.Len()
+			final.EncodeLevel(ent.Level, final)
+			if cur == final.buf.Len() {
+				// User-supplied EncodeLevel was a no-op. Fall back to strings.
+				final.AppendString(ent.Level.String())
+			}
+		}
+	case layoutTokenCaller:
+		if ent.Caller.Defined && final.CallerKey != "" {
+			final.appendTruncated(token.maxWidth, func(te *textEncoder) {
+				cur := te.buf.Len()
+				te.EncodeCaller(ent.Caller, te)
+				if cur == te.buf.Len() {
+					// User-supplied EncodeCaller was a no-op. Fall back to strings.
+					te.AppendString(ent.Caller.String())
+				}
+			})
+		}
+	case layoutTokenFunction:
+		if ent.Caller.Defined {
+			final.safeAddStringWithQuote(keepLastRunes(ent.Caller.Function, token.maxWidth))
+		}
+	case layoutTokenName:
+		if ent.LoggerName != "" && final.NameKey != "" {
+			nameEncoder := final.EncodeName
+			if nameEncoder == nil {
+				nameEncoder = zapcore.FullNameEncoder
+			}
+			final.appendTruncated(token.maxWidth, func(te *textEncoder) {
+				nameEncoder(ent.LoggerName, te)
+			})
+		}
+	case layoutTokenSeperator:
+		final.buf.AppendString(final.Seperator)
+	case layoutTokenMessage:
+		if final.MessageKey != "" {
+			final.safeAddStringWithQuote(keepLastRunes(final.limiter.truncateMessage(ent.Message), token.maxWidth))
+		}
+	case layoutTokenFields:
+		if enc.buf.Len() > 0 {
+			_, _ = final.buf.Write(enc.buf.Bytes())
+		}
+		final.addFields(fields)
+		final.closeOpenNamespaces()
+		final.openNamespaces = 0
+		final.addStack(ent)
+	}
+}
+
+// appendTruncated encodes the value by appendValue without quotes and escaping, keeps maxWidth runes of it,
+// and then appends it with quotes and escaping, zero maxWidth means no truncation
+func (final *textEncoder) appendTruncated(maxWidth int, appendValue func(te *textEncoder)) {
+	if maxWidth <= 0 {
+		appendValue(final)
+		return
+	}
+	settings := *final.textSettings
+	settings.DisableDoubleQuotes = true
+	settings.DisableEscape = true
+	te := final.nested()
+	te.textSettings = &settings
+	te.noLimit = final.noLimit
+	appendValue(te)
+	final.safeAddStringWithQuote(keepLastRunes(te.buf.String(), maxWidth))
+	te.buf.Free()
+	putTextEncoder(te)
+}
+
+// keepLastRunes keeps the right most n runes of s, zero n means no truncation
+func keepLastRunes(s string, n int) string {
+	count := utf8.RuneCountInString(s)
+	for ; n > 0 && count > n; count-- {
+		_, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+	}
+
+	return s
+}
+
+// appendLayoutToken appends the encoded token to the buffer with padding and truncation,
+// the width is counted in runes, the quoted tokens have been truncated by encodeLayoutToken()
+func appendLayoutToken(buf *buffer.Buffer, b []byte, token layoutToken) {
+	count := utf8.RuneCount(b)
+	if token.maxWidth > 0 && !token.quoted() && count > token.maxWidth {
+		// keep the right most characters like log4j does, as the beginning of the caller and the name is less useful
+		for ; count > token.maxWidth; count-- {
+			_, size := utf8.DecodeRune(b)
+			b = b[size:]
+		}
+	}
+	padding := token.width - count
+	if !token.leftAlign {
+		appendPadding(buf, padding)
+	}
+	_, _ = buf.Write(b)
+	if token.leftAlign {
+		appendPadding(buf, padding)
+	}
+}
@@ -0,0 +1,393 @@
+package log
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	TruncationMessage = "message"
+	TruncationField   = "field"
+	TruncationArray   = "array"
+	TruncationEntry   = "entry"
+
+	// DefaultTruncatedKey is the key of the marker which is added by the json encoder
+	// when the fields of an entry are dropped as the entry is too large
+	DefaultTruncatedKey = "truncated"
+
+	truncatedMarkerPrefix = "...(truncated "
+	truncatedMarkerBytes  = " bytes)"
+	truncatedMarkerElems  = " elements)"
+)
+
+var (
+	// the numbers of truncations of each kind
+	_truncationMessage int64
+	_truncationField   int64
+	_truncationArray   int64
+	_truncationEntry   int64
+)
+
+// LimitConfig serializes size limits related config in yaml/json,
+// the sizes are counted in bytes, zero means no limit
+type LimitConfig struct {
+	// MaxMessageLength is the maximum length of the message.
+	MaxMessageLength int `yaml:"max-message-length" json:"max-message-length"`
+	// MaxFieldLength is the maximum length of the string, byte string and reflected values of the fields.
+	MaxFieldLength int `yaml:"max-field-length" json:"max-field-length"`
+	// MaxArrayElements is the maximum number of the elements of an array.
+	MaxArrayElements int `yaml:"max-array-elements" json:"max-array-elements"`
+	// MaxEntrySize is the maximum size of an entry, including the line ending.
+	MaxEntrySize int `yaml:"max-entry-size" json:"max-entry-size"`
+}
+
+// GetTruncations returns the numbers of truncations of each kind,
+// the kinds are message, field, array and entry
+func GetTruncations() map[string]int64 {
+	return map[string]int64{
+		TruncationMessage: atomic.LoadInt64(&_truncationMessage),
+		TruncationField:   atomic.LoadInt64(&_truncationField),
+		TruncationArray:   atomic.LoadInt64(&_truncationArray),
+		TruncationEntry:   atomic.LoadInt64(&_truncationEntry),
+	}
+}
+
+// ResetTruncations resets the numbers of truncations to zero
+func ResetTruncations() {
+	atomic.StoreInt64(&_truncationMessage, 0)
+	atomic.StoreInt64(&_truncationField, 0)
+	atomic.StoreInt64(&_truncationArray, 0)
+	atomic.StoreInt64(&_truncationEntry, 0)
+}
+
+// limiter truncates the values which exceed the limits, all the methods are safe to call on a nil *limiter
+type limiter struct {
+	LimitConfig
+}
+
+// newLimiter returns a *limiter, it returns nil if there is no limit
+func newLimiter(lc LimitConfig) *limiter {
+	if lc.MaxMessageLength <= 0 && lc.MaxFieldLength <= 0 && lc.MaxArrayElements <= 0 && lc.MaxEntrySize <= 0 {
+		return nil
+	}
+
+	return &limiter{LimitConfig: lc}
+}
+
+// truncateMessage truncates the message if it exceeds MaxMessageLength
+func (l *limiter) truncateMessage(msg string) string {
+	if l == nil || l.MaxMessageLength <= 0 || len(msg) <= l.MaxMessageLength {
+		return msg
+	}
+	atomic.AddInt64(&_truncationMessage, 1)
+
+	return truncateString(msg, l.MaxMessageLength)
+}
+
+// fieldExceeded returns true if a value of given length exceeds MaxFieldLength
+func (l *limiter) fieldExceeded(length int) bool {
+	return l != nil && l.MaxFieldLength > 0 && length > l.MaxFieldLength
+}
+
+// truncateField truncates the field value if it exceeds MaxFieldLength
+func (l *limiter) truncateField(val string) string {
+	if !l.fieldExceeded(len(val)) {
+		return val
+	}
+	atomic.AddInt64(&_truncationField, 1)
+
+	return truncateString(val, l.MaxFieldLength)
+}
+
+// truncateFieldBytes truncates the field value if it exceeds MaxFieldLength,
+// it allocates a new slice only if the value is truncated
+func (l *limiter) truncateFieldBytes(val []byte) []byte {
+	if !l.fieldExceeded(len(val)) {
+		return val
+	}
+	atomic.AddInt64(&_truncationField, 1)
+
+	n := cutPoint(val, l.MaxFieldLength)
+	b := make([]byte, 0, n+len(truncatedMarkerPrefix)+len(truncatedMarkerBytes)+10)
+	b = append(b, val[:n]...)
+
+	return appendTruncatedMarker(b, len(val)-n, truncatedMarkerBytes)
+}
+
+// limitArray returns an array encoder which drops the elements exceeding MaxArrayElements,
+// it returns nil if there is no limit
+func (l *limiter) limitArray(enc zapcore.ArrayEncoder) *limitedArrayEncoder {
+	if l == nil || l.MaxArrayElements <= 0 {
+		return nil
+	}
+
+	return &limitedArrayEncoder{ArrayEncoder: enc, max: l.MaxArrayElements}
+}
+
+// entryExceeded returns true if an entry of given size exceeds MaxEntrySize
+func (l *limiter) entryExceeded(size int) bool {
+	return l != nil && l.MaxEntrySize > 0 && size > l.MaxEntrySize
+}
+
+// truncateEntry cuts the encoded entry to MaxEntrySize and counts the truncation
+func (l *limiter) truncateEntry(buf *buffer.Buffer, lineEnding string) {
+	if l.cutEntry(buf, lineEnding) {
+		atomic.AddInt64(&_truncationEntry, 1)
+	}
+}
+
+// cutEntry cuts the encoded entry to MaxEntrySize, the marker and the line ending are kept in the limit,
+// it returns true if the entry is cut
+func (l *limiter) cutEntry(buf *buffer.Buffer, lineEnding string) bool {
+	if !l.entryExceeded(buf.Len()) {
+		return false
+	}
+
+	b := buf.Bytes()
+	size := l.MaxEntrySize - len(lineEnding) - len(truncatedMarkerPrefix) - len(truncatedMarkerBytes) - len(strconv.Itoa(len(b)))
+	if size < 0 {
+		size = 0
+	}
+	n := cutPoint(b, size)
+	cut := len(b) - n
+	// the bytes are copied to the beginning of the same array, so it is safe to reuse them
+	buf.Reset()
+	_, _ = buf.Write(b[:n])
+	buf.AppendString(truncatedMarker(cut, truncatedMarkerBytes))
+	buf.AppendString(lineEnding)
+
+	return true
+}
+
+// fitMessage truncates the message until the entry fits in MaxEntrySize, encode encodes the entry with given message
+// and returns the size of it, orig is the original message and msg is the one truncated by MaxMessageLength,
+// the entry still exceeds the limit if it is too large even if the message is empty
+func (l *limiter) fitMessage(orig, msg string, encode func(msg string) int) {
+	markerSize := len(truncatedMarker(len(orig), truncatedMarkerBytes))
+	for n := len(msg); ; {
+		excess := encode(msg) - l.MaxEntrySize
+		if excess <= 0 || n == 0 {
+			return
+		}
+		// the escaped message may be longer than the raw one, so the size is checked again after encoding
+		n = len(msg) - excess - markerSize
+		if n < 0 {
+			n = 0
+		}
+		msg = truncateString(orig, n)
+	}
+}
+
+// limitedArrayEncoder is a zapcore.ArrayEncoder which drops the elements exceeding the max number
+type limitedArrayEncoder struct {
+	zapcore.ArrayEncoder
+	max     int
+	count   int
+	dropped int
+}
+
+// allow returns true if the element should be appended
+func (enc *limitedArrayEncoder) allow() bool {
+	enc.count++
+	if enc.count > enc.max {
+		enc.dropped++
+		return false
+	}
+
+	return true
+}
+
+// marker returns the marker of the dropped elements, it returns an empty string if no element is dropped,
+// the marker should be appended by the caller without the field length limit
+func (enc *limitedArrayEncoder) marker() string {
+	if enc.dropped == 0 {
+		return ""
+	}
+	atomic.AddInt64(&_truncationArray, 1)
+
+	return truncatedMarker(enc.dropped, truncatedMarkerElems)
+}
+
+func (enc *limitedArrayEncoder) AppendBool(v bool) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendBool(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendByteString(v []byte) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendByteString(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendComplex128(v complex128) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendComplex128(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendComplex64(v complex64) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendComplex64(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendFloat64(v float64) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendFloat64(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendFloat32(v float32) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendFloat32(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendInt(v int) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendInt(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendInt64(v int64) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendInt64(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendInt32(v int32) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendInt32(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendInt16(v int16) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendInt16(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendInt8(v int8) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendInt8(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendString(v string) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendString(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendUint(v uint) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendUint(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendUint64(v uint64) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendUint64(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendUint32(v uint32) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendUint32(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendUint16(v uint16) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendUint16(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendUint8(v uint8) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendUint8(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendUintptr(v uintptr) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendUintptr(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendDuration(v time.Duration) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendDuration(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendTime(v time.Time) {
+	if enc.allow() {
+		enc.ArrayEncoder.AppendTime(v)
+	}
+}
+
+func (enc *limitedArrayEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
+	if enc.allow() {
+		return enc.ArrayEncoder.AppendArray(v)
+	}
+
+	return nil
+}
+
+func (enc *limitedArrayEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	if enc.allow() {
+		return enc.ArrayEncoder.AppendObject(v)
+	}
+
+	return nil
+}
+
+func (enc *limitedArrayEncoder) AppendReflected(v interface{}) error {
+	if enc.allow() {
+		return enc.ArrayEncoder.AppendReflected(v)
+	}
+
+	return nil
+}
+
+// truncateString keeps the first n bytes of s and appends the marker, n must be less than the length of s
+func truncateString(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n] + truncatedMarker(len(s)-n, truncatedMarkerBytes)
+}
+
+// cutPoint returns the largest index which is not larger than n and is at the beginning of a rune,
+// so that the multi-byte characters will not be broken
+func cutPoint(b []byte, n int) int {
+	if n >= len(b) {
+		return len(b)
+	}
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+
+	return n
+}
+
+// truncatedMarker returns the marker of the truncated values, like ...(truncated 10 bytes)
+func truncatedMarker(n int, unit string) string {
+	return string(appendTruncatedMarker(make([]byte, 0, len(truncatedMarkerPrefix)+len(unit)+10), n, unit))
+}
+
+// appendTruncatedMarker appends the marker to b
+func appendTruncatedMarker(b []byte, n int, unit string) []byte {
+	b = append(b, truncatedMarkerPrefix...)
+	b = strconv.AppendInt(b, int64(n), 10)
+
+	return append(b, unit...)
+}
@@ -204,6 +204,7 @@ func InitLoggerWithConfig(cfg *Config) (*Logger, *ZapProperties, error) {
 
 	MyLogger = NewMyLogger(zapLogger)
 	ReplaceGlobals(MyLogger, MyProps)
+	setConfigVerbosity(cfg.Verbosity)
 
 	return MyLogger, MyProps, nil
 }
@@ -230,6 +231,18 @@ func InitFileLogger(fileName, level, format string, maxSize, maxDays, maxBackups
 	return InitLoggerWithConfig(cfg)
 }
 
+// InitFileLoggerWithRoutes initiates a file logger with given options and routes,
+// the routes write the entries in their level ranges to their own log files
+func InitFileLoggerWithRoutes(fileName, level, format string, maxSize, maxDays, maxBackups int, routes ...RouteConfig) (*Logger, *ZapProperties, error) {
+	cfg, err := NewConfigWithFileLog(fileName, level, format, maxSize, maxDays, maxBackups)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.Routes = routes
+
+	return InitLoggerWithConfig(cfg)
+}
+
 // InitFileLoggerWithDefaultConfig initiates logger with default options
 func InitFileLoggerWithDefault(fileName string) (*Logger, *ZapProperties, error) {
 	cfg, err := NewConfigWithFileLog(fileName, DefaultLogLevel, DefaultLogFormat, DefaultLogMaxSize, DefaultLogMaxDays, DefaultLogMaxBackups, nil)
@@ -248,9 +261,39 @@ func InitZapLoggerWithWriteSyncer(cfg *Config, output zapcore.WriteSyncer, opts
 		return nil, nil, errors.Trace(err)
 	}
 
-	core := NewTextCore(newZapTextEncoder(cfg).(*textEncoder), output, level)
+	enc, err := newZapEncoder(cfg, output)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	core := NewTextCore(enc, output, level)
+	if cfg.Dedup != nil {
+		core.(*textIOCore).dedup = newDeduper(cfg.Dedup, output)
+	}
+	outputs, err := newOutputCores(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	routes, err := newRouteCores(cfg)
+	if err != nil {
+		// the log files of the outputs have been opened
+		_ = closeOutputCores(outputs)
+		return nil, nil, err
+	}
+	outputs = append(outputs, routes...)
+	for _, o := range outputs {
+		core.(*textIOCore).addOutput(o)
+	}
+	sampled := core
+	if cfg.Sampler != nil {
+		sampled, err = NewSamplerWithConfig(core, cfg.Sampler)
+		if err != nil {
+			_ = closeOutputCores(outputs)
+			return nil, nil, err
+		}
+	}
 	opts = append(cfg.buildOptions(output), opts...)
-	lg := zap.New(core, opts...)
+	lg := zap.New(sampled, opts...)
 	r := &ZapProperties{
 		Core:   core,
 		Syncer: output,
@@ -260,9 +303,17 @@ func InitZapLoggerWithWriteSyncer(cfg *Config, output zapcore.WriteSyncer, opts
 	return lg, r, nil
 }
 
-// CloneLogger returns a fresh new logger with same options
+// CloneLogger returns a fresh new logger with same options, unlike With(),
+// changing the settings, adding the outputs or the hooks of the clone at runtime does not affect the logger
 func CloneLogger(logger *Logger) *Logger {
-	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core { return c.With([]zapcore.Field{}) }))
+	return logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		clone := c.With([]zapcore.Field{})
+		core, err := getTextIOCore(clone)
+		if err == nil {
+			core.detach()
+		}
+		return clone
+	}))
 }
 
 // init initiate MyLogger when this package is imported
@@ -1,13 +1,27 @@
 package log
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/pingcap/errors"
 	"github.com/romberli/go-multierror"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func newRoutine(t *testing.T, wg *sync.WaitGroup) {
@@ -89,13 +103,19 @@ func TestLog(t *testing.T) {
 	wg.Wait()
 
 	t.Log("==========test clone==========")
-	CloneStdoutLogger().Info("this is cloned logger info message")
-	CloneStdoutLogger().Infof("this is cloned logger infof message")
+	cloned := CloneStdoutLogger()
+	cloned.Info("this is cloned logger info message")
+	cloned.Infof("this is cloned logger infof message")
 	Info("this is original logger info message, which should not be printed to console")
 	t.Log("==========test clone==========")
 
 	t.Log("==========add stdout to logger started==========")
-	MyLogger.CloneAndAddWriteSyncer(NewStdoutWriteSyncer()).Info("test CloneAndAddWriteSyncer()")
+	cloned = MyLogger.CloneAndAddWriteSyncer(NewStdoutWriteSyncer())
+	cloned.Info("test CloneAndAddWriteSyncer()")
+	_, err = CloneWithWriteSyncer(NewStdoutWriteSyncer())
+	asst.Nil(err, "clone with write syncer failed")
+	_, err = NewMyLogger(zap.NewNop()).CloneWithWriteSyncer(NewStdoutWriteSyncer())
+	asst.NotNil(err, "adding write syncer to a core which is not a *textIOCore should fail")
 	MyLogger.Info("mylogger info message after test CloneAndAddWriteSyncer, this should not be printed to console")
 	stdoutSyncer := NewStdoutWriteSyncer()
 	AddWriteSyncer(stdoutSyncer)
@@ -208,3 +228,1371 @@ func TestGlobalLogger(t *testing.T) {
 	Debug("debug message after set level to warn")
 	Errorf("errorf message after set level to warn")
 }
+
+func TestJSONEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatJSON)
+	cfg.TimeFormat = TimeFormatMilliSecond
+	cfg.DisableErrorVerbose = true
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Time:    time.Date(2021, 1, 2, 3, 4, 5, 6000000, time.Local),
+		Message: "json \"message\"",
+		Caller:  zapcore.NewEntryCaller(0, "/path/to/log.go", 10, true),
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.String("key", "value"), zap.Error(funcC())})
+	asst.Nil(err, "encode entry failed")
+
+	m := make(map[string]interface{})
+	err = json.Unmarshal(buf.Bytes(), &m)
+	asst.Nil(err, "unmarshal json entry failed")
+	asst.Equal("ERROR", m["level"])
+	asst.Equal("2021-01-02 03:04:05.006", m["time"])
+	asst.Equal("log.go:10", m["caller"])
+	asst.Equal("json \"message\"", m["message"])
+	asst.Equal("value", m["key"])
+	asst.Equal("function error", m["error"])
+	asst.NotContains(m, "errorVerbose")
+
+	asst.Nil(enc.SetTimeFormat(TimeFormatMicroSecond), "set time format failed")
+	asst.NotNil(enc.SetSeperator(DefaultLogSeparator), "set seperator should fail with json encoder")
+}
+
+func TestConsoleEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatConsole)
+	cfg.Color = ColorAlways
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+
+	ent := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Time:    time.Now(),
+		Message: "console message",
+		Caller:  zapcore.NewEntryCaller(0, "/path/to/log.go", 10, true),
+		Stack:   "main.main\n\t/path/to/main.go:10",
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.String("key", "value"), zap.Error(funcC())})
+	asst.Nil(err, "encode entry failed")
+
+	lines := strings.Split(buf.String(), "\n")
+	asst.Contains(lines[0], colorRed+"[ERROR]"+colorReset+"  log.go:10")
+	asst.True(strings.HasSuffix(lines[0], "console message [key=value] [error=\"function error\"]"))
+	asst.Equal(consoleIndent+"errorVerbose:", lines[1])
+	asst.Contains(buf.String(), consoleIndent+"stack:\n"+consoleIndent+consoleIndent+"main.main\n")
+
+	cfg.Color = ColorNever
+	asst.False(cfg.enableColor(NewStdoutWriteSyncer()), "color should be disabled")
+
+	// the color level encoders do not nest the color codes of the console encoder
+	for _, c := range []struct {
+		levelEncoder string
+		color        string
+		expect       string
+	}{
+		{LevelEncoderCapitalColor, ColorAlways, colorRed + "[ERROR]" + colorReset + "  "},
+		{LevelEncoderColor, ColorAlways, colorRed + "[error]" + colorReset + "  "},
+		{LevelEncoderCapitalColor, ColorNever, "[" + colorRed + "ERROR" + colorReset + "]  "},
+		{LevelEncoderCapital, ColorNever, "[ERROR]  "},
+	} {
+		cfg.Color = c.color
+		cfg.Encoder.LevelEncoder = c.levelEncoder
+		cfg.Encoder.TimeKey = EncoderKeyOmit
+		enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+		asst.Nil(err, "create encoder failed")
+		buf, err = enc.EncodeEntry(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "message"}, nil)
+		asst.Nil(err, "encode entry failed")
+		asst.Equal(c.expect+"message\n", buf.String(), "level encoder %s with color %s", c.levelEncoder, c.color)
+	}
+}
+
+func TestEncoderConfig(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatJSON)
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local),
+		LoggerName: "main.sub",
+		Message:    "encoder config",
+		Caller:     zapcore.NewEntryCaller(0, "/path/to/log.go", 10, true),
+	}
+	fields := []zapcore.Field{zap.Duration("elapsed", 1500*time.Millisecond)}
+	buf, err := enc.EncodeEntry(ent, fields)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"WARN","time":"2021-01-02 03:04:05.000000","name":"main.sub","caller":"log.go:10","message":"encoder config","elapsed":"1.5s"}`+"\n", buf.String())
+
+	cfg.Encoder = EncoderConfig{
+		TimeKey:         "ts",
+		LevelKey:        "severity",
+		CallerKey:       EncoderKeyOmit,
+		MessageKey:      "msg",
+		LevelEncoder:    LevelEncoderLower,
+		DurationEncoder: DurationEncoderSeconds,
+		NameEncoder:     NameEncoderShort,
+	}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, fields)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"severity":"warn","ts":"2021-01-02 03:04:05.000000","name":"sub","msg":"encoder config","elapsed":1.5}`+"\n", buf.String())
+
+	cfg.Format = LogFormatText
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, fields)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[2021-01-02 03:04:05.000000][warn][sub]["encoder config"][elapsed=1.5]`+"\n", buf.String())
+
+	cfg.Encoder = EncoderConfig{}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, fields)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[2021-01-02 03:04:05.000000][log.go:10][WARN][main.sub]["encoder config"][elapsed=1.5s]`+"\n", buf.String())
+
+	cfg.Encoder = EncoderConfig{TimeKey: EncoderKeyOmit, LevelKey: EncoderKeyOmit, DurationEncoder: DurationEncoderMillis}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, fields)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[log.go:10][main.sub]["encoder config"][elapsed=1500]`+"\n", buf.String())
+}
+
+func TestLayout(t *testing.T) {
+	asst := assert.New(t)
+
+	_, err := parseLayout("%time %unknown")
+	asst.NotNil(err, "unknown token should fail")
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Layout = "%time %unknown"
+	_, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid layout should fail")
+	ent := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Date(2021, 1, 2, 3, 4, 5, 0, time.Local),
+		Message: "layout message",
+		Caller:  zapcore.NewEntryCaller(0, "/path/to/log.go", 10, true),
+	}
+	cfg.Encoder.TimeKey = EncoderKeyOmit
+	buf, err := NewTextEncoder(cfg).EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("[log.go:10][INFO][\"layout message\"]\n", buf.String(), "invalid layout should fall back to the default layout")
+	cfg.Encoder.TimeKey = ""
+
+	cfg.Layout = "%d|%-5p|%8.6caller|%m %fields %%"
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("key", "value")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("2021-01-02 03:04:05.000000|INFO |  .go:10|\"layout message\" [key=value] %\n", buf.String())
+
+	cfg.Layout = "[%time][%level][%caller] %msg"
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("[2021-01-02 03:04:05.000000][INFO][log.go:10] \"layout message\"\n", buf.String())
+
+	// the fields are appended after a space if the layout does not contain %fields
+	cfg.Layout = "%level|%msg"
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("INFO|\"layout message\"\n", buf.String())
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("key", "value")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("INFO|\"layout message\" [key=value]\n", buf.String())
+
+	// the raw values are truncated before being quoted and escaped
+	cfg.Layout = "%.5name|%.4msg|%.3msg"
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent.LoggerName = "main sub"
+	ent.Message = "say \"hi\"\n"
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`"n sub"|"hi\"\n"|"i\"\n"`+"\n", buf.String())
+	ent.Message = " x"
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`"n sub"|" x"|" x"`+"\n", buf.String())
+}
+
+type credential struct {
+	User     string
+	Password string
+}
+
+func (c *credential) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("user", c.User)
+	enc.AddString("password", c.Password)
+	return nil
+}
+
+func TestRedact(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Redact = RedactConfig{Keys: []string{"[invalid"}}
+	_, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid redact key should fail")
+	cfg.Redact = RedactConfig{Mode: "unknown"}
+	_, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid redact mode should fail")
+
+	cfg.Encoder.TimeKey = EncoderKeyOmit
+	cfg.Redact = RedactConfig{Keys: DefaultRedactKeys}
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "redact"}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.String("Authorization", "Bearer abc"),
+		zap.Int("db_password", 123456),
+		zap.Object("cred", &credential{User: "root", Password: "root123"}),
+		zap.NamedError("token_error", errors.New("token abc is expired")),
+		zap.Namespace("secret"),
+		zap.String("key", "value"),
+	})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[INFO][redact][Authorization=***][db_password=***][cred="{user=root,password=***}"][token_error=***][secret={][key=***]}`+"\n", buf.String())
+
+	// the keys in the reflected values are not matched
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Reflect("user", credential{User: "root", Password: "root123"})})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[INFO][redact][user="{\"User\":\"root\",\"Password\":\"root123\"}"]`+"\n", buf.String())
+
+	cfg.Redact = RedactConfig{Keys: []string{"USER"}, Mask: "<hidden>"}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("user", "root"), zap.String("host", "localhost")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("[INFO][redact][user=<hidden>][host=localhost]\n", buf.String())
+
+	cfg.Format = LogFormatJSON
+	cfg.Redact = RedactConfig{Keys: []string{"token"}, Mode: RedactModeHash, Salt: "salt"}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("token", "abc"), zap.String("user", "root")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","message":"redact","token":"sha256:3681099918be28c9","user":"root"}`+"\n", buf.String())
+}
+
+func TestLimit(t *testing.T) {
+	asst := assert.New(t)
+
+	ResetTruncations()
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Encoder.TimeKey = EncoderKeyOmit
+	cfg.Limit = LimitConfig{MaxMessageLength: 5, MaxFieldLength: 8, MaxArrayElements: 2}
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "hello world"}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.String("str", "abcdefghijkl"),
+		zap.String("utf8", "测试测试"),
+		zap.Ints("ints", []int{1, 2, 3, 4}),
+		zap.Reflect("reflect", map[string]string{"key": "value"}),
+	})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[INFO]["hello...(truncated 6 bytes)"][str="abcdefgh...(truncated 4 bytes)"][utf8="测试...(truncated 6 bytes)"]`+
+		`[ints="[1,2,\"...(truncated 2 elements)\"]"][reflect="{\"key\":\"...(truncated 7 bytes)"]`+"\n", buf.String())
+	asst.Equal(map[string]int64{TruncationMessage: 1, TruncationField: 3, TruncationArray: 1, TruncationEntry: 0}, GetTruncations())
+
+	// the header items are not truncated by MaxFieldLength
+	ResetTruncations()
+	cfg.Limit = LimitConfig{MaxFieldLength: 3}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	caller := ent
+	caller.LoggerName = "main"
+	caller.Caller = zapcore.EntryCaller{Defined: true, File: "/path/to/log.go", Line: 10}
+	buf, err = enc.EncodeEntry(caller, []zapcore.Field{zap.String("str", "abcd")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[log.go:10][INFO][main]["hello world"][str="abc...(truncated 1 bytes)"]`+"\n", buf.String())
+	cfg.Format = LogFormatJSON
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(caller, []zapcore.Field{zap.String("str", "abcd")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","name":"main","caller":"log.go:10","message":"hello world","str":"abc...(truncated 1 bytes)"}`+"\n", buf.String())
+	asst.Equal(int64(2), GetTruncations()[TruncationField])
+
+	// the fields are dropped if the entry exceeds MaxEntrySize
+	ResetTruncations()
+	cfg.Format = LogFormatText
+	cfg.Limit = LimitConfig{MaxEntrySize: 48}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("str", strings.Repeat("a", 100))})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[INFO]["hello world"][s...(truncated 105 bytes)`+"\n", buf.String())
+	cfg.Format = LogFormatJSON
+	cfg.Limit = LimitConfig{MaxEntrySize: 96}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("str", strings.Repeat("a", 100))})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","message":"hello world","truncated":"...(truncated 96 bytes)"}`+"\n", buf.String())
+	asst.Equal(int64(2), GetTruncations()[TruncationEntry])
+
+	// the json message is truncated if the entry is still too large, so that the entry is always a complete json object
+	cfg.Limit = LimitConfig{MaxEntrySize: 120}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	long := ent
+	long.Message = strings.Repeat("a", 100)
+	buf, err = enc.EncodeEntry(long, []zapcore.Field{zap.String("str", "abc")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","message":"aaaaaaaaaaaaaaaaaaaaaaaaaaaa...(truncated 72 bytes)","truncated":"...(truncated 48 bytes)"}`+"\n", buf.String())
+	asst.True(json.Valid(buf.Bytes()), "the entry should be a valid json object")
+	long.Message = strings.Repeat(`"a`, 100)
+	buf, err = enc.EncodeEntry(long, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.LessOrEqual(buf.Len(), 120, "the escaped message should be truncated")
+	asst.True(json.Valid(buf.Bytes()), "the entry should be a valid json object")
+
+	ResetTruncations()
+	cfg.Limit = LimitConfig{MaxArrayElements: 1}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Ints("ints", []int{1, 2, 3})})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","message":"hello world","ints":[1,"...(truncated 2 elements)"]}`+"\n", buf.String())
+	asst.Equal(int64(1), GetTruncations()[TruncationArray])
+
+	// nothing is truncated within the limits
+	ResetTruncations()
+	cfg.Format = LogFormatText
+	cfg.Limit = LimitConfig{MaxMessageLength: 11, MaxFieldLength: 3, MaxArrayElements: 3, MaxEntrySize: 64}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("str", "abc"), zap.Ints("ints", []int{1, 2, 3})})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[INFO]["hello world"][str=abc][ints="[1,2,3]"]`+"\n", buf.String())
+	asst.Equal(map[string]int64{TruncationMessage: 0, TruncationField: 0, TruncationArray: 0, TruncationEntry: 0}, GetTruncations())
+
+	// the field values are always truncated while the header items are encoded concurrently
+	var text lockedBuilder
+	cfg.Limit = LimitConfig{MaxFieldLength: 3}
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&text))
+	asst.Nil(err, "init logger failed")
+	logger := zapLogger.With(zap.String("service", "log"))
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logger.Info("limit", zap.String("str", "abcd"))
+			}
+		}()
+	}
+	wg.Wait()
+	asst.Equal(800, strings.Count(text.String(), `[str="abc...(truncated 1 bytes)"]`), "the fields should be truncated")
+}
+
+func TestTimeZone(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.TimeZone = "Invalid/Zone"
+	_, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid time zone should fail")
+
+	cfg.TimeZone = "UTC"
+	cfg.TimeFormat = TimeFormatRFC3339Nano
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 123456789, time.FixedZone("CST", 8*3600))
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: ts, Message: "time zone"}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.Time("ts", ts), zap.Times("times", []time.Time{ts, ts})})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[2021-01-01T19:04:05.123456789Z][INFO]["time zone"][ts=2021-01-01T19:04:05.123456789Z]`+
+		`[times="[2021-01-01T19:04:05.123456789Z,2021-01-01T19:04:05.123456789Z]"]`+"\n", buf.String())
+	// the time zone is kept when the time format is changed at runtime
+	asst.Nil(enc.SetTimeFormat(TimeFormatUnixMilli), "set time format failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Time("ts", ts)})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[1609527845123][INFO]["time zone"][ts=1609527845123]`+"\n", buf.String())
+
+	cfg.TimeZone = "Asia/Tokyo"
+	cfg.TimeFormat = TimeFormatMilliSecond
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Time("ts", ts)})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[2021-01-02 04:04:05.123][INFO]["time zone"][ts=2021-01-02 04:04:05.123]`+"\n", buf.String())
+
+	cfg.Format = LogFormatJSON
+	cfg.TimeFormat = TimeFormatISO8601
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Time("ts", ts)})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","time":"2021-01-02T04:04:05.123+0900","message":"time zone","ts":"2021-01-02T04:04:05.123+0900"}`+"\n", buf.String())
+	asst.Nil(enc.SetTimeFormat(TimeFormatUnix), "set time format failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Time("ts", ts)})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`{"level":"INFO","time":1609527845,"message":"time zone","ts":1609527845}`+"\n", buf.String())
+}
+
+func TestCallerEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Encoder.CallerEncoder = "unknown"
+	_, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid caller encoder should fail")
+
+	pc, file, line, _ := runtime.Caller(0)
+	caller := zapcore.NewEntryCaller(pc, file, line, true)
+	caller.Function = runtime.FuncForPC(pc).Name()
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "caller encoder", Caller: caller}
+
+	cfg.Encoder.CallerEncoder = CallerEncoderFull
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	expects := map[string]string{
+		CallerEncoderShort:          fmt.Sprintf("[log_test.go:%d]", line),
+		CallerEncoderFull:           fmt.Sprintf("[%s:%d]", file, line),
+		CallerEncoderModuleRelative: fmt.Sprintf("[log_test.go:%d]", line),
+		CallerEncoderPackage:        fmt.Sprintf("[%s/log_test.go:%d]", filepath.Base(filepath.Dir(file)), line),
+		CallerEncoderFunction:       fmt.Sprintf(`["log_test.go:%d log.TestCallerEncoder"]`, line),
+	}
+	for _, name := range []string{CallerEncoderFull, CallerEncoderShort, CallerEncoderModuleRelative, CallerEncoderPackage, CallerEncoderFunction} {
+		err = enc.SetCallerEncoder(name)
+		asst.Nil(err, "set caller encoder failed")
+		buf, err := enc.EncodeEntry(ent, nil)
+		asst.Nil(err, "encode entry failed")
+		asst.Contains(buf.String(), expects[name], name)
+	}
+	asst.NotNil(enc.SetCallerEncoder("unknown"), "invalid caller encoder should fail")
+}
+
+func TestStack(t *testing.T) {
+	asst := assert.New(t)
+
+	stack := strings.Join([]string{
+		"github.com/romberli/log.(*Logger).Error",
+		"\t/src/log/logger.go:156",
+		"main.handle",
+		"\t/src/app/main.go:20",
+		"main.serve",
+		"\t/src/app/main.go:30",
+		"runtime.goexit",
+		"\t/go/src/runtime/asm_amd64.s:1571",
+	}, "\n")
+	ent := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Now(), Message: "stack", Stack: stack}
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Stack = StackConfig{Structured: true, Filters: DefaultStackFilters, MaxDepth: 1}
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err := enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	asst.Equal(2, len(lines))
+	asst.Equal("    main.handle /src/app/main.go:20", lines[1])
+
+	cfg.Stack = StackConfig{Filters: DefaultStackFilters}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Contains(buf.String(), `[stack="main.handle\n\t/src/app/main.go:20\nmain.serve\n\t/src/app/main.go:30"]`)
+
+	cfg.Format = LogFormatJSON
+	cfg.Stack = StackConfig{Structured: true, Filters: DefaultStackFilters}
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	m := make(map[string]interface{})
+	err = json.Unmarshal(buf.Bytes(), &m)
+	asst.Nil(err, "unmarshal json entry failed")
+	asst.Equal([]interface{}{
+		map[string]interface{}{"function": "main.handle", "file": "/src/app/main.go", "line": float64(20)},
+		map[string]interface{}{"function": "main.serve", "file": "/src/app/main.go", "line": float64(30)},
+	}, m[DefaultStacktraceKey])
+
+	ent.Stack = "not a stack trace"
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Contains(buf.String(), `"stack":"not a stack trace"`)
+}
+
+func TestErrorChain(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatJSON)
+	cfg.Encoder.ErrorEncoder = ErrorEncoderChain
+	cfg.Stack = StackConfig{MaxDepth: 1}
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+
+	merr := multierror.Append(&multierror.Error{}, errors.Wrap(funcA(), "wrapped"), fmt.Errorf("std: %w", errors.New("root")))
+	ent := zapcore.Entry{Level: zapcore.ErrorLevel, Time: time.Now(), Message: "error chain"}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.Error(merr)})
+	asst.Nil(err, "encode entry failed")
+
+	m := make(map[string]interface{})
+	err = json.Unmarshal(buf.Bytes(), &m)
+	asst.Nil(err, "unmarshal json entry failed")
+	asst.Equal(merr.Error(), m["error"])
+	asst.Nil(m["errorVerbose"])
+	chain, ok := m["errorChain"].([]interface{})
+	asst.True(ok, "error chain should be an array")
+
+	var (
+		messages []string
+		types    []string
+	)
+	for _, c := range chain {
+		cause := c.(map[string]interface{})
+		messages = append(messages, cause["message"].(string))
+		types = append(types, cause["type"].(string))
+		if cause["message"] == "function error" {
+			frames := cause["stack"].([]interface{})
+			asst.Equal(1, len(frames), "stack frames should be capped")
+			asst.Equal("github.com/romberli/log.funcA", frames[0].(map[string]interface{})["function"])
+		}
+	}
+	asst.Equal([]string{merr.Error(), "wrapped: function error", "wrapped: function error", "function error", "std: root", "root"}, messages)
+	asst.Equal([]string{"*multierror.Error", "*errors.withStack", "*errors.withMessage", "*errors.fundamental", "*fmt.wrapError", "*errors.fundamental"}, types)
+
+	cfg.Format = LogFormatConsole
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Error(funcA())})
+	asst.Nil(err, "encode entry failed")
+	asst.Contains(buf.String(), "    errorChain:\n        *errors.fundamental: function error\n            github.com/romberli/log.funcA ")
+}
+
+type point struct {
+	X int
+	Y int
+}
+
+func TestTypeEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	RegisterTypeEncoder(reflect.TypeOf(point{}), func(v interface{}, enc zapcore.PrimitiveArrayEncoder) {
+		p := v.(point)
+		enc.AppendString(fmt.Sprintf("(%d,%d)", p.X, p.Y))
+	})
+	defer RegisterTypeEncoder(reflect.TypeOf(point{}), nil)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Encoder.UseTextMarshaler = true
+	cfg.Encoder.UseStringer = true
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "type encoder"}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.Reflect("point", point{X: 1, Y: 2}),
+		zap.Reflect("pointer", &point{X: 3, Y: 4}),
+		zap.Reflect("nil", (*point)(nil)),
+		zap.Reflect("ip", net.ParseIP("127.0.0.1")),
+		zap.Reflect("big", big.NewInt(12345)),
+		zap.Reflect("map", map[string]int{"a": 1}),
+	})
+	asst.Nil(err, "encode entry failed")
+	s := buf.String()
+	asst.Contains(s, "[point=(1,2)]")
+	asst.Contains(s, "[pointer=(3,4)]")
+	asst.Contains(s, "[nil=null]")
+	asst.Contains(s, "[ip=127.0.0.1]")
+	asst.Contains(s, "[big=12345]")
+	asst.Contains(s, `[map="{\"a\":1}"]`)
+
+	cfg.Format = LogFormatJSON
+	cfg.Encoder.UseTextMarshaler = false
+	cfg.Encoder.UseStringer = false
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{
+		zap.Reflect("point", point{X: 1, Y: 2}),
+		zap.Reflect("ip", net.ParseIP("127.0.0.1")),
+	})
+	asst.Nil(err, "encode entry failed")
+	s = buf.String()
+	asst.Contains(s, `"point":"(1,2)"`)
+	asst.Contains(s, `"ip":"127.0.0.1"`, "net.IP implements json.Marshaler")
+}
+
+type httpRequest struct {
+	Method string
+	Path   string
+}
+
+func (r httpRequest) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString("method", r.Method)
+	enc.AddString("path", r.Path)
+	return nil
+}
+
+func TestDottedKeys(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Encoder.DottedKeys = true
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	var sb strings.Builder
+	core := NewTextCore(enc, zapcore.AddSync(&sb), zapcore.DebugLevel)
+	core = core.With([]zapcore.Field{zap.String("app", "test"), zap.Namespace("http")})
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "dotted keys"}
+	err = core.Write(ent, []zapcore.Field{
+		zap.Object("request", httpRequest{Method: "GET", Path: "/"}),
+		zap.Ints("codes", []int{200, 304}),
+		zap.Namespace("response"),
+		zap.Int("status", 200),
+	})
+	asst.Nil(err, "write entry failed")
+	s := sb.String()
+	asst.Contains(s, `[app=test][http.request.method=GET][http.request.path=/][http.codes="[200,304]"][http.response.status=200]`)
+	asst.NotContains(s, "{")
+
+	cfg.Format = LogFormatConsole
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.Object("request", httpRequest{Method: "GET", Path: "/"})})
+	asst.Nil(err, "encode entry failed")
+	asst.Contains(buf.String(), "[request.method=GET][request.path=/]")
+
+	cfg.Format = LogFormatText
+	cfg.Encoder.DottedKeys = false
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Object("request", httpRequest{Method: "GET", Path: "/"})})
+	asst.Nil(err, "encode entry failed")
+	asst.Contains(buf.String(), `[request="{method=GET,path=/}"]`)
+}
+
+func TestSyslogEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatSyslog)
+	cfg.TimeZone = "UTC"
+	cfg.Syslog = SyslogConfig{Facility: "local0", Hostname: "host", AppName: "app", MsgID: "req"}
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	var sb strings.Builder
+	core := NewTextCore(enc, zapcore.AddSync(&sb), zapcore.DebugLevel)
+	core = core.With([]zapcore.Field{zap.String("app", "test"), zap.Namespace("http")})
+	ent := zapcore.Entry{Level: zapcore.WarnLevel, Time: time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC), Message: "syslog message"}
+	err = core.Write(ent, []zapcore.Field{
+		zap.Object("request", httpRequest{Method: "GET", Path: "/a]b"}),
+		zap.Ints("codes", []int{200, 304}),
+		zap.String("quote", `say "hi"`),
+		zap.Int("status", 200),
+	})
+	asst.Nil(err, "write entry failed")
+	pid := strconv.Itoa(os.Getpid())
+	asst.Equal(`<132>1 2024-01-02T03:04:05.000006Z host app `+pid+` req [fields@32473 app="test" http.request.method="GET" http.request.path="/a\]b" http.codes="[200,304\]" http.quote="say \"hi\"" http.status="200"] syslog message`+"\n", sb.String())
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.DebugLevel, Time: ent.Time}, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("<135>1 2024-01-02T03:04:05.000006Z host app "+pid+" req -\n", buf.String())
+
+	cfg.Syslog.Protocol = SyslogProtocolRFC3164
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.Int("status", 200)})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal("<132>Jan  2 03:04:05 host app["+pid+`]: syslog message [fields@32473 status="200"]`+"\n", buf.String())
+	asst.NotNil(enc.SetTimeFormat(TimeFormatRFC3339Nano), "time format is defined by the protocol")
+
+	cfg.Syslog.Facility = "unknown"
+	_, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "facility should be validated")
+}
+
+func TestGELFEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatGELF)
+	cfg.GELF.Host = "host"
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent := zapcore.Entry{
+		Level:      zapcore.ErrorLevel,
+		Time:       time.Unix(1704164645, 6000),
+		LoggerName: "main",
+		Message:    "gelf message",
+		Stack:      "stack",
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.Object("request", httpRequest{Method: "GET", Path: "/"}),
+		zap.Int("id", 1),
+		zap.Float64("ratio", 0.5),
+		zap.String("quote", `say "hi"`),
+	})
+	asst.Nil(err, "encode entry failed")
+	s := buf.String()
+	asst.Equal(`{"version":"1.1","host":"host","short_message":"gelf message","full_message":"gelf message\nstack","timestamp":1704164645.000006,"level":3,"_name":"main","_request.method":"GET","_request.path":"/","__id":1,"_ratio":0.5,"_quote":"say \"hi\""}`+"\n", s)
+	m := make(map[string]interface{})
+	asst.Nil(json.Unmarshal(buf.Bytes(), &m), "gelf message should be a valid json object")
+
+	cfg.Limit.MaxEntrySize = 150
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err = enc.EncodeEntry(ent, []zapcore.Field{zap.String("value", strings.Repeat("a", 200))})
+	asst.Nil(err, "encode entry failed")
+	asst.Nil(json.Unmarshal(buf.Bytes(), &m), "truncated gelf message should be a valid json object")
+	asst.Contains(m["_truncated"], "truncated")
+	cfg.Limit.MaxEntrySize = 200
+	enc, err = newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent.Message = strings.Repeat("a", 200)
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.LessOrEqual(buf.Len(), 200, "the message should be truncated")
+	asst.Nil(json.Unmarshal(buf.Bytes(), &m), "gelf message with the truncated message should be a valid json object")
+}
+
+func TestCEFEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatCEF)
+	cfg.CEF = CEFConfig{Vendor: "romberli", Product: "log|test", Version: "1.0", Host: "host"}
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	ent := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Time:    time.Unix(1704164645, 6000000),
+		Caller:  zapcore.NewEntryCaller(0, "/path/main.go", 10, true),
+		Message: "cef message",
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{
+		zap.Object("request", httpRequest{Method: "GET", Path: "/a=b"}),
+		zap.String("lines", "a\nb"),
+	})
+	asst.Nil(err, "encode entry failed")
+	pid := strconv.Itoa(os.Getpid())
+	asst.Equal(`CEF:0|romberli|log\|test|1.0|WARN|cef message|5|dvchost=host dvcpid=`+pid+` rt=1704164645006 cs1Label=caller cs1=main.go:10 request.method=GET request.path=/a\=b lines=a\nb`+"\n", buf.String())
+}
+
+func TestGlogEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatGlog)
+	cfg.TimeZone = "UTC"
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	asst.NotNil(enc.SetTimeFormat(TimeFormatMilliSecond), "glog encoder should not support time format")
+	ent := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Time:    time.Unix(1704164645, 6000000).UTC(),
+		Caller:  zapcore.NewEntryCaller(0, "/path/main.go", 10, true),
+		Message: "glog message",
+	}
+	buf, err := enc.EncodeEntry(ent, []zapcore.Field{zap.String("user", "alice"), zap.Int("count", 2)})
+	asst.Nil(err, "encode entry failed")
+	pid := fmt.Sprintf("%7d", os.Getpid())
+	asst.Equal(`W0102 03:04:05.006000 `+pid+` main.go:10] glog message [user=alice][count=2]`+"\n", buf.String())
+
+	ent.Level = zapcore.DebugLevel
+	ent.Caller = zapcore.EntryCaller{}
+	buf, err = enc.EncodeEntry(ent, nil)
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`I0102 03:04:05.006000 `+pid+` ???:1] glog message`+"\n", buf.String())
+}
+
+func TestVerbosity(t *testing.T) {
+	asst := assert.New(t)
+
+	defer func(verbosity int, flagSet int32, l *Logger, s *zap.SugaredLogger, p *ZapProperties, v *Logger) {
+		SetVerbosity(verbosity)
+		_verbosityFlagSet = flagSet
+		_globalL, _globalS, _globalP, _globalV = l, s, p, v
+	}(GetVerbosity(), _verbosityFlagSet, _globalL, _globalS, _globalP, _globalV)
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatGlog)
+	var out strings.Builder
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&out))
+	asst.Nil(err, "init logger failed")
+	logger := NewMyLogger(zapLogger)
+
+	SetVerbosity(1)
+	asst.True(logger.V(1).Enabled(), "V(1) should be enabled")
+	asst.False(logger.V(2).Enabled(), "V(2) should not be enabled")
+	logger.V(2).Info("verbose message")
+	asst.Empty(out.String(), "V(2) should not log")
+	logger.V(1).Infof("verbose %s", "message")
+	asst.Contains(out.String(), " log_test.go:", "caller should be the test file")
+	asst.True(strings.HasSuffix(out.String(), "] verbose message\n"), "V(1) should log")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	InitVerbosityFlag(fs)
+	asst.Nil(fs.Parse([]string{"-v=3"}), "parse flags failed")
+	asst.Equal(3, GetVerbosity(), "verbosity should be set by the -v flag")
+
+	// the -v flag takes precedence over the config, and zero verbosity leaves the threshold unchanged
+	cases := []struct {
+		flagSet   int32
+		verbosity int
+		expected  int
+	}{
+		{1, 0, 3},
+		{1, 5, 3},
+		{0, 0, 3},
+		{0, 5, 5},
+	}
+	for _, c := range cases {
+		SetVerbosity(3)
+		_verbosityFlagSet = c.flagSet
+		cfg = NewConfigWithStdout(DefaultLogLevel, LogFormatGlog)
+		cfg.Verbosity = c.verbosity
+		_, _, err = InitLoggerWithConfig(cfg)
+		asst.Nil(err, "init logger failed")
+		asst.Equal(c.expected, GetVerbosity())
+	}
+
+	// V() of global logger does not clone the logger
+	allocs := testing.AllocsPerRun(10, func() {
+		_ = V(1).Enabled()
+	})
+	asst.Equal(float64(0), allocs, "V() should not allocate")
+}
+
+func TestParser(t *testing.T) {
+	asst := assert.New(t)
+
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Unix(1704164645, 6000000),
+		LoggerName: "nm",
+		Caller:     zapcore.NewEntryCaller(0, "/path/main.go", 10, true),
+		Message:    "hello \"w\"\n[x] a=b",
+		Stack:      "main.main\n\t/path/main.go:10",
+	}
+	fields := []zapcore.Field{
+		zap.String("a", "b c"),
+		zap.Int("n", 1),
+		zap.Strings("arr", []string{"a", "b c"}),
+		zap.Error(errors.New("bad")),
+		zap.Namespace("ns"),
+		zap.Float64("f", 1.5),
+		zap.String("path", `C:\tmp`),
+	}
+	for _, c := range []struct {
+		disableDoubleQuotes bool
+		disableEscape       bool
+		seperator           string
+	}{{false, false, ""}, {false, true, ""}, {true, false, DefaultLogSeparator}} {
+		cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+		cfg.DisableDoubleQuotes = c.disableDoubleQuotes
+		cfg.DisableEscape = c.disableEscape
+		enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+		asst.Nil(err, "create encoder failed")
+		asst.Nil(enc.SetSeperator(c.seperator), "set seperator failed")
+		parser, err := NewParser(cfg)
+		asst.Nil(err, "create parser failed")
+		asst.Nil(parser.enc.SetSeperator(c.seperator), "set seperator failed")
+
+		msgEnt := ent
+		if c.disableDoubleQuotes {
+			// the unquoted message containing = is ambiguous
+			msgEnt.Message = "hello \"w\"\n[x]"
+		}
+		buf, err := enc.EncodeEntry(msgEnt, fields)
+		asst.Nil(err, "encode entry failed")
+		line := buf.String()
+
+		scanner, err := NewScanner(strings.NewReader(line+line), cfg)
+		asst.Nil(err, "create scanner failed")
+		scanner.parser = parser
+		count := 0
+		for scanner.Scan() {
+			count++
+			parsed := scanner.Entry()
+			asst.True(msgEnt.Time.Equal(parsed.Time), "time should be parsed")
+			asst.Equal(msgEnt.Level, parsed.Level, "level should be parsed")
+			asst.Equal(msgEnt.LoggerName, parsed.LoggerName, "logger name should be parsed")
+			asst.Equal(msgEnt.Message, parsed.Message, "message should be parsed")
+			asst.Equal(msgEnt.Stack, parsed.Stack, "stack should be parsed")
+			asst.Equal("main.go", parsed.Caller.File, "caller should be parsed")
+			asst.Equal(10, parsed.Caller.Line, "caller should be parsed")
+			// encoding the parsed entry outputs the same line
+			reencoded, err := enc.EncodeEntry(parsed, scanner.Fields())
+			asst.Nil(err, "encode entry failed")
+			asst.Equal(line, reencoded.String(), "round trip should output the same line")
+		}
+		asst.Nil(scanner.Err(), "scan failed")
+		asst.Equal(2, count, "scanner should read all the entries")
+	}
+
+	parser, err := NewParser(NewConfigWithStdout(DefaultLogLevel, LogFormatText))
+	asst.Nil(err, "create parser failed")
+	_, fields, err = parser.Parse(`[2024-01-02 03:04:05.000006][INFO][msg] [a="b]c"][n=1]` + "\n")
+	asst.Nil(err, "parse failed")
+	asst.Equal([]zapcore.Field{zap.String("a", "b]c"), zap.String("n", "1")}, fields)
+	_, _, err = parser.Parse(`[2024-01-02 03:04:05.000006][INFO][msg][a="b`)
+	asst.NotNil(err, "incomplete entry should fail")
+
+	// the values of { and } are quoted, so that they are not parsed as the namespaces
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Encoder.TimeKey = EncoderKeyOmit
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	asst.Nil(err, "create encoder failed")
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "{"}, []zapcore.Field{zap.String("open", "{"), zap.String("close", "}")})
+	asst.Nil(err, "encode entry failed")
+	asst.Equal(`[INFO]["{"][open="{"][close="}"]`+"\n", buf.String())
+	parser, err = NewParser(cfg)
+	asst.Nil(err, "create parser failed")
+	parsed, fields, err := parser.Parse(buf.String())
+	asst.Nil(err, "parse failed")
+	asst.Equal("{", parsed.Message)
+	asst.Equal([]zapcore.Field{zap.String("open", "{"), zap.String("close", "}")}, fields)
+	_, err = NewParser(NewConfigWithStdout(DefaultLogLevel, LogFormatJSON))
+	asst.NotNil(err, "json format should not be supported")
+
+	// the ambiguous outputs which are not parsed as they were encoded
+	cases := []struct {
+		name                string
+		disableDoubleQuotes bool
+		disableEscape       bool
+		ent                 zapcore.Entry
+		fields              []zapcore.Field
+		line                string
+		parsedName          string
+		parsedMessage       string
+		parsedFields        []zapcore.Field
+		fail                bool
+	}{
+		{
+			name:                "unquoted message containing =",
+			disableDoubleQuotes: true,
+			ent:                 zapcore.Entry{Message: "a=b"},
+			line:                "[INFO][a=b]\n",
+			parsedFields:        []zapcore.Field{zap.String("a", "b")},
+		},
+		{
+			name:                "unquoted message containing ]",
+			disableDoubleQuotes: true,
+			ent:                 zapcore.Entry{Message: "a]b"},
+			line:                "[INFO][a]b]\n",
+			fail:                true,
+		},
+		{
+			name:                "unquoted message containing [",
+			disableDoubleQuotes: true,
+			ent:                 zapcore.Entry{Message: "a[b"},
+			line:                "[INFO][a[b]\n",
+			fail:                true,
+		},
+		{
+			name:                "unquoted value containing ]",
+			disableDoubleQuotes: true,
+			ent:                 zapcore.Entry{Message: "msg"},
+			fields:              []zapcore.Field{zap.String("k", "a]b")},
+			line:                "[INFO][msg][k=a]b]\n",
+			fail:                true,
+		},
+		{
+			name:                "unquoted value of {",
+			disableDoubleQuotes: true,
+			ent:                 zapcore.Entry{Message: "msg"},
+			fields:              []zapcore.Field{zap.String("k", "{")},
+			line:                "[INFO][msg][k={]\n",
+			fail:                true,
+		},
+		{
+			name:          `quoted value containing "]`,
+			disableEscape: true,
+			ent:           zapcore.Entry{Message: "msg"},
+			fields:        []zapcore.Field{zap.String("k", `a b"][x="y`)},
+			line:          `[INFO][msg][k="a b"][x="y"]` + "\n",
+			parsedMessage: "msg",
+			parsedFields:  []zapcore.Field{zap.String("k", "a b"), zap.String("x", "y")},
+		},
+		{
+			name:          `quoted value ending with "]`,
+			disableEscape: true,
+			ent:           zapcore.Entry{Message: "msg"},
+			fields:        []zapcore.Field{zap.String("k", `a"]b`)},
+			line:          `[INFO][msg][k="a"]b"]` + "\n",
+			fail:          true,
+		},
+		{
+			name:          `quoted message containing "=`,
+			disableEscape: true,
+			ent:           zapcore.Entry{Message: `a "=b`},
+			line:          `[INFO]["a "=b"]` + "\n",
+			parsedFields:  []zapcore.Field{zap.String("a ", `b"`)},
+		},
+		{
+			name:          "logger name without message",
+			ent:           zapcore.Entry{LoggerName: "nm"},
+			line:          "[INFO][nm]\n",
+			parsedMessage: "nm",
+		},
+	}
+	for _, c := range cases {
+		cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+		cfg.Encoder.TimeKey = EncoderKeyOmit
+		cfg.DisableDoubleQuotes = c.disableDoubleQuotes
+		cfg.DisableEscape = c.disableEscape
+		enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+		asst.Nil(err, "create encoder failed")
+		buf, err := enc.EncodeEntry(c.ent, c.fields)
+		asst.Nil(err, "encode entry failed")
+		asst.Equal(c.line, buf.String(), c.name)
+
+		parser, err = NewParser(cfg)
+		asst.Nil(err, "create parser failed")
+		parsed, fields, err := parser.Parse(c.line)
+		if c.fail {
+			asst.NotNil(err, c.name)
+			continue
+		}
+		asst.Nil(err, c.name)
+		asst.Equal(c.parsedName, parsed.LoggerName, c.name)
+		asst.Equal(c.parsedMessage, parsed.Message, c.name)
+		asst.Equal(c.parsedFields, fields, c.name)
+	}
+}
+
+func benchmarkEncoder(b *testing.B, format string, extra ...zapcore.Field) {
+	cfg := NewConfigWithStdout(DefaultLogLevel, format)
+	enc, err := newZapEncoder(cfg, NewStdoutWriteSyncer())
+	if err != nil {
+		b.Fatal(err)
+	}
+	enc.addFields([]zapcore.Field{zap.String("service", "log"), zap.Int("pid", 1234)})
+	benchmarkEntry(b, enc, extra...)
+}
+
+// benchmarkEntry benchmarks encoding the same entry with the encoder
+func benchmarkEntry(b *testing.B, enc zapcore.Encoder, extra ...zapcore.Field) {
+	ent := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Unix(1704164645, 6000000),
+		Caller:  zapcore.NewEntryCaller(0, "/path/main.go", 10, true),
+		Message: "benchmark message",
+	}
+	fields := []zapcore.Field{
+		zap.String("user", "alice"),
+		zap.Int("count", 2),
+		zap.Bool("ok", true),
+		zap.Float64("ratio", 0.5),
+		zap.Duration("elapsed", time.Millisecond),
+		zap.Time("start", time.Unix(1704164645, 0)),
+	}
+	fields = append(fields, extra...)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf, err := enc.EncodeEntry(ent, fields)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buf.Free()
+	}
+}
+
+func BenchmarkTextEncoder(b *testing.B) {
+	benchmarkEncoder(b, LogFormatText)
+}
+
+func BenchmarkConsoleEncoder(b *testing.B) {
+	benchmarkEncoder(b, LogFormatConsole)
+}
+
+func BenchmarkJSONEncoder(b *testing.B) {
+	benchmarkEncoder(b, LogFormatJSON)
+}
+
+// BenchmarkZapJSONEncoder is the baseline of the encoders above, it encodes the same entry with the json encoder of zap
+func BenchmarkZapJSONEncoder(b *testing.B) {
+	enc := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	zap.String("service", "log").AddTo(enc)
+	zap.Int("pid", 1234).AddTo(enc)
+	benchmarkEntry(b, enc)
+}
+
+func BenchmarkTextEncoderError(b *testing.B) {
+	benchmarkEncoder(b, LogFormatText, zap.Error(errors.New("benchmark error")))
+}
+
+func BenchmarkJSONEncoderError(b *testing.B) {
+	benchmarkEncoder(b, LogFormatJSON, zap.Error(errors.New("benchmark error")))
+}
+
+func TestStringDurationEncoder(t *testing.T) {
+	asst := assert.New(t)
+
+	for _, d := range []time.Duration{
+		0, 1, 999, time.Microsecond, 1500 * time.Nanosecond, time.Millisecond, 1200 * time.Microsecond,
+		time.Second, 90 * time.Second, 3*time.Hour + 2*time.Minute + 500*time.Millisecond, -time.Minute,
+		math.MaxInt64, math.MinInt64,
+	} {
+		buf := _pool.Get()
+		appendDuration(buf, d)
+		asst.Equal(d.String(), buf.String(), "duration should be formatted as time.Duration.String()")
+		buf.Free()
+	}
+}
+
+func TestRuntimeReconfiguration(t *testing.T) {
+	asst := assert.New(t)
+
+	var text, json strings.Builder
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.DisableCaller = true
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.Lock(zapcore.AddSync(&text)))
+	asst.Nil(err, "init logger failed")
+	logger := NewMyLogger(zapLogger)
+	jsonCfg := NewConfigWithStdout(DefaultLogLevel, LogFormatJSON)
+	jsonCfg.DisableCaller = true
+	zapLogger, _, err = InitZapLoggerWithWriteSyncer(jsonCfg, zapcore.AddSync(&json))
+	asst.Nil(err, "init logger failed")
+	jsonLogger := NewMyLogger(zapLogger)
+
+	// the settings changed after With() reach the child loggers
+	child := logger.WithOptions(zap.Fields(zap.String("service", "log")))
+	asst.Nil(logger.SetSeperator(DefaultLogSeparator), "set seperator failed")
+	asst.Nil(logger.SetDisableDoubleQuotes(true), "set disable double quotes failed")
+	child.Info("child message")
+	asst.Contains(text.String(), "[INFO]"+DefaultLogSeparator+"[child message] [service=log]")
+	jsonChild := jsonLogger.WithOptions(zap.Fields(zap.String("service", "log")))
+	asst.Nil(jsonLogger.SetTimeFormat("2006"), "set time format failed")
+	jsonChild.Info("child message")
+	asst.Contains(json.String(), fmt.Sprintf(`"time":"%d"`, time.Now().Year()))
+
+	// the settings of the clones are copied, changing them does not affect the logger
+	text.Reset()
+	clone := logger.Clone()
+	asst.Nil(clone.SetSeperator(""), "set seperator failed")
+	asst.Nil(clone.SetDisableDoubleQuotes(false), "set disable double quotes failed")
+	clone.Info("clone message")
+	logger.Info("logger message")
+	asst.Contains(text.String(), "[INFO][\"clone message\"]\n")
+	asst.Contains(text.String(), "[INFO]"+DefaultLogSeparator+"[logger message]\n")
+	json.Reset()
+	jsonClone := jsonLogger.Clone()
+	asst.Nil(jsonClone.SetTimeFormat(TimeFormatUnix), "set time format failed")
+	jsonLogger.Info("logger message")
+	asst.Contains(json.String(), fmt.Sprintf(`"time":"%d"`, time.Now().Year()))
+
+	// the settings are changed while the other goroutines are encoding
+	text.Reset()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				child.Info("concurrent message", zap.Int("j", j))
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		asst.Nil(logger.SetDisableDoubleQuotes(i%2 == 0), "set disable double quotes failed")
+		asst.Nil(logger.SetTimeFormat(TimeFormatMilliSecond), "set time format failed")
+	}
+	wg.Wait()
+	asst.Equal(400, strings.Count(text.String(), "concurrent message"), "all the entries should be written")
+}
+
+func TestSampledLogger(t *testing.T) {
+	asst := assert.New(t)
+
+	var text, added strings.Builder
+	dropped := 0
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.DisableCaller = true
+	cfg.Sampling = &zap.SamplingConfig{Initial: 1, Thereafter: 0, Hook: func(ent zapcore.Entry, decision zapcore.SamplingDecision) {
+		if decision == zapcore.LogDropped {
+			dropped++
+		}
+	}}
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&text))
+	asst.Nil(err, "init logger failed")
+	logger := NewMyLogger(zapLogger)
+	_, ok := logger.zapLogger.Core().(CoreUnwrapper)
+	asst.True(ok, "the core should be wrapped by the sampler")
+
+	// the runtime setters and AddWriteSyncer() work through the sampler
+	asst.Nil(logger.SetSeperator(DefaultLogSeparator), "set seperator failed")
+	asst.Nil(logger.SetTimeFormat(TimeFormatMilliSecond), "set time format failed")
+	asst.Nil(logger.AddWriteSyncer(zapcore.AddSync(&added)), "add write syncer failed")
+	child := logger.WithOptions(zap.Fields(zap.String("service", "log")))
+	child.Info("sampled message")
+	child.Info("sampled message")
+	asst.Equal(1, strings.Count(text.String(), "sampled message"), "the repeated entry should be dropped")
+	asst.Equal(1, dropped, "the hook should be called with the dropped entry")
+	asst.Contains(text.String(), "[INFO]"+DefaultLogSeparator+`["sampled message"] [service=log]`)
+	asst.Equal(text.String(), added.String(), "the added write syncer should have the same entries")
+
+	// the log file is rotated through the sampler
+	dir, err := os.MkdirTemp("", "log-sampler")
+	asst.Nil(err, "create temp dir failed")
+	defer os.RemoveAll(dir)
+	cfg, err = NewConfigWithFileLog(filepath.Join(dir, "run.log"), DefaultLogLevel, LogFormatText, DefaultLogMaxSize, DefaultLogMaxDays, DefaultLogMaxBackups)
+	asst.Nil(err, "create config failed")
+	cfg.Sampling = &zap.SamplingConfig{Initial: 100, Thereafter: 100}
+	fileLogger, _, err := InitLoggerWithConfig(cfg)
+	asst.Nil(err, "init logger failed")
+	fileLogger.Info("file message")
+	asst.Nil(fileLogger.Rotate(), "rotate failed")
+	files, err := os.ReadDir(dir)
+	asst.Nil(err, "read dir failed")
+	asst.Equal(2, len(files), "log file should be rotated")
+	_, _, err = InitStdoutLoggerWithDefault()
+	asst.Nil(err, "init logger failed")
+}
+
+func TestRoutes(t *testing.T) {
+	asst := assert.New(t)
+
+	dir, err := os.MkdirTemp("", "log-routes")
+	asst.Nil(err, "create temp dir failed")
+	defer os.RemoveAll(dir)
+
+	var text lockedBuilder
+	cfg := newOutputTestConfig("debug", LogFormatText)
+	cfg.DisableStacktrace = true
+	cfg.Routes = []RouteConfig{
+		{MinLevel: "error", File: FileLogConfig{FileName: filepath.Join(dir, "error.log")}},
+		{MinLevel: "info", MaxLevel: "warn", File: FileLogConfig{FileName: filepath.Join(dir, "info.log")}},
+		{MaxLevel: "info", File: FileLogConfig{FileName: filepath.Join(dir, "debug.log")}},
+		{MinLevel: "warn", MaxLevel: "warn", File: FileLogConfig{FileName: filepath.Join(dir, "warn.log")}},
+	}
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&text))
+	asst.Nil(err, "init logger failed")
+	logger := NewMyLogger(zapLogger)
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Warn("warn message")
+	logger.Error("error message")
+	asst.Nil(logger.Sync(), "sync failed")
+	asst.Equal("[DEBUG][\"debug message\"]\n[INFO][\"info message\"]\n[WARN][\"warn message\"]\n[ERROR][\"error message\"]\n",
+		text.String(), "the logger should have all the entries")
+	content, err := os.ReadFile(filepath.Join(dir, "error.log"))
+	asst.Nil(err, "read log file failed")
+	asst.Equal("[ERROR][\"error message\"]\n", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "info.log"))
+	asst.Nil(err, "read log file failed")
+	asst.Equal("[INFO][\"info message\"]\n[WARN][\"warn message\"]\n", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "debug.log"))
+	asst.Nil(err, "read log file failed")
+	asst.Equal("[DEBUG][\"debug message\"]\n[INFO][\"info message\"]\n", string(content))
+	content, err = os.ReadFile(filepath.Join(dir, "warn.log"))
+	asst.Nil(err, "read log file failed")
+	asst.Equal("[WARN][\"warn message\"]\n", string(content))
+
+	// all the log files are rotated
+	errorRoute, err := NewRouteConfig("error", "", filepath.Join(dir, "rotate", "error.log"), DefaultLogMaxSize, DefaultLogMaxDays, DefaultLogMaxBackups)
+	asst.Nil(err, "create route config failed")
+	fileLogger, _, err := InitFileLoggerWithRoutes(filepath.Join(dir, "rotate", "run.log"), "debug", LogFormatText,
+		DefaultLogMaxSize, DefaultLogMaxDays, DefaultLogMaxBackups, *errorRoute)
+	asst.Nil(err, "init logger failed")
+	fileLogger.Error("error message")
+	asst.Nil(fileLogger.Rotate(), "rotate failed")
+	files, err := os.ReadDir(filepath.Join(dir, "rotate"))
+	asst.Nil(err, "read dir failed")
+	asst.Equal(4, len(files), "all the log files should be rotated")
+	_, _, err = InitStdoutLoggerWithDefault()
+	asst.Nil(err, "init logger failed")
+
+	cfg = NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Routes = []RouteConfig{{MinLevel: "error", MaxLevel: "warn", File: FileLogConfig{FileName: filepath.Join(dir, "wrong.log")}}}
+	_, _, err = InitZapLoggerWithWriteSyncer(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid level range should fail")
+	cfg.Routes = []RouteConfig{{MinLevel: "wrong", File: FileLogConfig{FileName: filepath.Join(dir, "wrong.log")}}}
+	_, _, err = InitZapLoggerWithWriteSyncer(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid min level should fail")
+	cfg.Routes = []RouteConfig{{MaxLevel: "wrong", File: FileLogConfig{FileName: filepath.Join(dir, "wrong.log")}}}
+	_, _, err = InitZapLoggerWithWriteSyncer(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid max level should fail")
+	cfg.Routes = []RouteConfig{{MinLevel: "error"}}
+	_, _, err = InitZapLoggerWithWriteSyncer(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "empty file name should fail")
+}
+
+func TestHooks(t *testing.T) {
+	asst := assert.New(t)
+
+	var text, errOutput strings.Builder
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&text), zap.ErrorOutput(zapcore.AddSync(&errOutput)))
+	asst.Nil(err, "init logger failed")
+	logger := NewMyLogger(zapLogger)
+	child := logger.WithOptions(zap.Fields(zap.String("service", "log")))
+
+	counts := make(map[zapcore.Level]int)
+	asst.Nil(logger.AddHook(func(ent zapcore.Entry, fields []zapcore.Field) error {
+		counts[ent.Level]++
+		return nil
+	}), "add hook failed")
+	var audit []zapcore.Field
+	asst.Nil(logger.AddHook(func(ent zapcore.Entry, fields []zapcore.Field) error {
+		audit = append(audit, fields...)
+		return errors.New("audit store is unavailable")
+	}, zapcore.WarnLevel), "add hook failed")
+
+	child.Debug("debug message")
+	child.Info("info message", zap.Int("id", 1))
+	child.Warn("warn message", zap.Int("id", 2))
+	asst.Equal(map[zapcore.Level]int{zapcore.InfoLevel: 1, zapcore.WarnLevel: 1}, counts, "the hook should be called with the written entries")
+	asst.Equal([]zapcore.Field{zap.String("service", "log"), zap.Int("id", 2)}, audit, "the hook should get the fields added by With()")
+	asst.Contains(errOutput.String(), "audit store is unavailable", "the error of the hook should be reported")
+	asst.Equal(2, strings.Count(text.String(), "message"), "the entries should be written before the hooks")
+
+	asst.NotNil(NewMyLogger(zap.NewNop()).AddHook(func(zapcore.Entry, []zapcore.Field) error { return nil }), "the core should be a *textIOCore")
+
+	// the invalid levels are rejected instead of being ignored or meaning all the levels
+	called := false
+	for _, level := range []zapcore.Level{zapcore.DebugLevel - 1, zapcore.FatalLevel + 1, zapcore.Level(64), zapcore.Level(-128)} {
+		err = logger.AddHook(func(zapcore.Entry, []zapcore.Field) error {
+			called = true
+			return nil
+		}, zapcore.InfoLevel, level)
+		asst.Equal(fmt.Sprintf(ErrInvalidHookLevel, level.String(), "debug", "fatal"), err.Error())
+	}
+	logger.Info("info message")
+	asst.False(called, "the hooks with invalid levels should not be added")
+
+	// the hook added to the clone is called by the child of the clone, but not by the logger
+	clone := CloneLogger(logger)
+	cloneChild := clone.WithOptions(zap.Fields(zap.String("service", "clone")))
+	cloneCount := 0
+	asst.Nil(clone.AddHook(func(zapcore.Entry, []zapcore.Field) error {
+		cloneCount++
+		return nil
+	}), "add hook failed")
+	logger.Info("info message")
+	asst.Equal(0, cloneCount, "the hook added to the clone should not be called by the logger")
+	clone.Info("info message")
+	cloneChild.Info("info message")
+	asst.Equal(2, cloneCount, "the hook added to the clone should be called by the clone and its child")
+}
+
+// lockedBuilder is a strings.Builder which could be written and read concurrently
+type lockedBuilder struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (b *lockedBuilder) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.Write(p)
+}
+
+func (b *lockedBuilder) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sb.String()
+}
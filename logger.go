@@ -2,6 +2,7 @@ package log
 
 import (
 	"github.com/pingcap/errors"
+	"github.com/romberli/go-multierror"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -22,38 +23,89 @@ func NewMyLogger(logger *zap.Logger) *Logger {
 
 // Rotate rotates log file
 func (logger *Logger) Rotate() error {
-	core, ok := logger.zapLogger.Core().(*textIOCore)
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return errors.New("failed to rotate log file, " + ErrNotTextIOCore)
+	}
+
+	if len(core.outputs.load().cores) > 0 {
+		// the log files of the logger and the outputs are rotated together,
+		// the write syncers which are not lumberjack writers are skipped, as some outputs may be stdout
+		err = rotateWriteSyncers(core.ListWriteSyncer())
+		if err != nil {
+			return err
+		}
+		return rotateWriteSyncers(core.ListOutputWriteSyncer())
+	}
+
+	ws, ok := core.GetWriterSyncer().(*WriteSyncer)
 	if ok {
-		ws, ok := core.GetWriterSyncer().(*WriteSyncer)
+		w, ok := ws.GetWriter().(*Writer)
 		if ok {
-			w, ok := ws.GetWriter().(*Writer)
-			if ok {
-				return w.Rotate()
-			} else {
-				return errors.New("failed to rotate log file, make sure use lumberjack writer as the writer")
-			}
+			return w.Rotate()
+		} else {
+			return errors.New("failed to rotate log file, make sure use lumberjack writer as the writer")
 		}
+	}
+
+	mws, ok := core.GetWriterSyncer().(MultiWriteSyncer)
+	if ok {
+		return rotateWriteSyncers(mws)
+	}
+
+	return errors.New("failed to rotate log file, " + ErrNotTextIOCore)
+}
+
+// Sync flushes the buffered log entries, including the summaries of the suppressed duplicates
+func (logger *Logger) Sync() error {
+	return logger.zapLogger.Sync()
+}
+
+// Close stops the report goroutine of the sampler of the logger and syncs the logger,
+// the logger could still be used after it is closed, but the numbers of the sampled and the dropped entries are not reported
+func (logger *Logger) Close() error {
+	var err *multierror.Error
+	s, e := getSampler(logger.zapLogger.Core())
+	if e == nil {
+		err = multierror.Append(err, s.Close())
+	}
+	err = multierror.Append(err, logger.Sync())
+
+	return err.ErrorOrNil()
+}
 
-		mws, ok := core.GetWriterSyncer().(MultiWriteSyncer)
+// rotateWriteSyncers rotates the lumberjack writers of the write syncers, the other write syncers are skipped
+func rotateWriteSyncers(syncerList []zapcore.WriteSyncer) error {
+	for _, ws := range syncerList {
+		s, ok := ws.(*WriteSyncer)
 		if ok {
-			for _, ws := range mws {
-				s, ok := ws.(*WriteSyncer)
-				if ok {
-					w, ok := s.GetWriter().(*Writer)
-					if ok {
-						err := w.Rotate()
-						if err != nil {
-							return err
-						}
-					}
+			w, ok := s.GetWriter().(*Writer)
+			if ok {
+				err := w.Rotate()
+				if err != nil {
+					return err
 				}
 			}
+		}
+	}
+
+	return nil
+}
 
-			return nil
+// closeWriteSyncers closes the lumberjack writers of the write syncers, the other write syncers are skipped
+func closeWriteSyncers(syncerList []zapcore.WriteSyncer) error {
+	var err *multierror.Error
+	for _, ws := range syncerList {
+		s, ok := ws.(*WriteSyncer)
+		if ok {
+			w, ok := s.GetWriter().(*Writer)
+			if ok {
+				err = multierror.Append(err, w.Close())
+			}
 		}
 	}
 
-	return errors.New("failed to rotate log file, make sure the core of the logger is a *textIOCore")
+	return err.ErrorOrNil()
 }
 
 // Clone clones logger and returns the new one
@@ -62,37 +114,157 @@ func (logger *Logger) Clone() *Logger {
 }
 
 // SetTimeFormat sets the time format of log message
-func (logger *Logger) SetTimeFormat(timeFormat string) {
-	logger.zapLogger.Core().(*textIOCore).SetTimeFormat(timeFormat)
+func (logger *Logger) SetTimeFormat(timeFormat string) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	return core.SetTimeFormat(timeFormat)
+}
+
+// SetCallerEncoder sets the caller encoder of log message, it is one of short, full, module-relative, package or function
+func (logger *Logger) SetCallerEncoder(callerEncoder string) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	return core.SetCallerEncoder(callerEncoder)
 }
 
 // SetSeperator sets the seperator to log message
-func (logger *Logger) SetSeperator(seperator string) {
-	logger.zapLogger.Core().(*textIOCore).SetSeperator(seperator)
+func (logger *Logger) SetSeperator(seperator string) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	return core.SetSeperator(seperator)
 }
 
 // SetDisableDoubleQuotes disables wrapping log content with double quotes
-func (logger *Logger) SetDisableDoubleQuotes(disableDoubleQuotes bool) {
-	logger.zapLogger.Core().(*textIOCore).SetDisableDoubleQuotes(disableDoubleQuotes)
+func (logger *Logger) SetDisableDoubleQuotes(disableDoubleQuotes bool) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	return core.SetDisableDoubleQuotes(disableDoubleQuotes)
 }
 
 // SetDisableEscape disables escaping special characters of log content like \n,\r...
-func (logger *Logger) SetDisableEscape(disableEscape bool) {
-	logger.zapLogger.Core().(*textIOCore).SetDisableEscape(disableEscape)
+func (logger *Logger) SetDisableEscape(disableEscape bool) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	return core.SetDisableEscape(disableEscape)
 }
 
 // AddWriteSyncer adds write syncer to multi write syncer, which allows to add a new way to write log message
-func (logger *Logger) AddWriteSyncer(ws zapcore.WriteSyncer) {
-	logger.zapLogger.Core().(*textIOCore).AddWriteSyncer(ws)
+func (logger *Logger) AddWriteSyncer(ws zapcore.WriteSyncer) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	core.AddWriteSyncer(ws)
+
+	return nil
+}
+
+// AddWriteSyncerWithConfig adds an output which writes log message to the write syncer with its own level,
+// format and encoder options of the config, the file config is ignored
+func (logger *Logger) AddWriteSyncerWithConfig(ws zapcore.WriteSyncer, cfg *Config) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	output, err := newOutputCore(cfg, ws)
+	if err != nil {
+		return err
+	}
+	core.addOutput(output)
+
+	return nil
 }
 
-// CloneAndAddWriteSyncer adds write syncer to multi write syncer, which allows to add a new way to write log message
+// AddHook adds a hook which is called with each entry of the levels written by the logger,
+// empty levels means all the levels, for example: logger.AddHook(hook, zapcore.ErrorLevel, zapcore.FatalLevel),
+// an error is returned if any of the levels is not in [DebugLevel, FatalLevel]
+func (logger *Logger) AddHook(hook Hook, levels ...zapcore.Level) error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	return core.AddHook(hook, levels...)
+}
+
+// GetSamplingStats returns the numbers of the sampled and the dropped entries of the levels,
+// an error is returned if the logger is not sampled by Config.Sampler or Config.Sampling
+func (logger *Logger) GetSamplingStats() (SamplingStats, error) {
+	s, err := getSampler(logger.zapLogger.Core())
+	if err != nil {
+		return SamplingStats{}, err
+	}
+
+	return s.samplingStats(), nil
+}
+
+// GetSecretHits returns the numbers of masked secrets of each detector of the logger and its outputs,
+// an error is returned if the core of the logger is not a *textIOCore
+func (logger *Logger) GetSecretHits() (map[string]int64, error) {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[string]int64)
+	for _, s := range core.secretScanners() {
+		s.addHits(hits)
+	}
+
+	return hits, nil
+}
+
+// ResetSecretHits resets the numbers of masked secrets of the logger and its outputs to zero
+func (logger *Logger) ResetSecretHits() error {
+	core, err := getTextIOCore(logger.zapLogger.Core())
+	if err != nil {
+		return err
+	}
+
+	for _, s := range core.secretScanners() {
+		s.resetHits()
+	}
+
+	return nil
+}
+
+// CloneAndAddWriteSyncer adds write syncer to multi write syncer, which allows to add a new way to write log message,
+// the clone is returned without the write syncer if it could not be added, use CloneWithWriteSyncer() to get the error
 func (logger *Logger) CloneAndAddWriteSyncer(ws zapcore.WriteSyncer) *Logger {
 	c := logger.Clone()
-	c.AddWriteSyncer(ws)
+	_ = c.AddWriteSyncer(ws)
 	return c
 }
 
+// CloneWithWriteSyncer clones the logger and adds write syncer to multi write syncer of the clone,
+// an error is returned if the write syncer could not be added, for example, the core of the logger is not a *textIOCore
+func (logger *Logger) CloneWithWriteSyncer(ws zapcore.WriteSyncer) (*Logger, error) {
+	c := logger.Clone()
+	err := c.AddWriteSyncer(ws)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 // WithOptions returns a new *Logger with specified options
 func (logger *Logger) WithOptions(opts ...zap.Option) *Logger {
 	return &Logger{
@@ -106,6 +278,12 @@ func (logger *Logger) Sugar() *zap.SugaredLogger {
 	return logger.zapLogger.Sugar()
 }
 
+// V returns a Verbose which logs at InfoLevel only if level is not larger than the verbosity threshold,
+// for example: logger.V(2).Info("message")
+func (logger *Logger) V(level int) Verbose {
+	return newVerbose(logger, level)
+}
+
 // Debug logs a message at DebugLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func (logger *Logger) Debug(msg string, fields ...zap.Field) {
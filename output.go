@@ -0,0 +1,157 @@
+package log
+
+import (
+	"github.com/pingcap/errors"
+	"github.com/romberli/go-multierror"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// OutputConfig serializes the config of an additional output in yaml/json,
+// each output has its own write syncer, level, format and encoder options,
+// the empty level, format, time format, layout and color are inherited from the config of the logger.
+type OutputConfig struct {
+	// Level is the minimum level of the output.
+	Level string `yaml:"level" json:"level"`
+	// Format of the output. one of json, text, console, syslog, gelf, cef or glog.
+	Format string `yaml:"format" json:"format"`
+	// TimeFormat is the layout of the timestamps in the output.
+	TimeFormat string `yaml:"time-format" json:"time-format"`
+	// Layout is the pattern layout of text format.
+	Layout string `yaml:"layout" json:"layout"`
+	// Color colorizes the level of console format, one of auto, always or never.
+	Color string `yaml:"color" json:"color"`
+	// DisableDoubleQuotes disables adding double-quotes to log entry of the output.
+	DisableDoubleQuotes bool `yaml:"disable-double-quotes" json:"disable-double-quotes"`
+	// DisableEscape disables escaping special characters of the output.
+	DisableEscape bool `yaml:"disable-escape" json:"disable-escape"`
+	// File is the log file of the output, the output is stdout if the file name is empty.
+	File FileLogConfig `yaml:"file" json:"file"`
+}
+
+// config returns the config of the output, which is the config of the logger overridden by the output config
+func (oc OutputConfig) config(cfg *Config) *Config {
+	c := *cfg
+	c.Outputs = nil
+	c.Routes = nil
+	c.File = oc.File
+	if oc.Level != "" {
+		c.Level = oc.Level
+	}
+	if oc.Format != "" {
+		c.Format = oc.Format
+	}
+	if oc.TimeFormat != "" {
+		c.TimeFormat = oc.TimeFormat
+	}
+	if oc.Layout != "" {
+		c.Layout = oc.Layout
+	}
+	if oc.Color != "" {
+		c.Color = oc.Color
+	}
+	c.DisableDoubleQuotes = oc.DisableDoubleQuotes
+	c.DisableEscape = oc.DisableEscape
+
+	return &c
+}
+
+// newOutputCore returns a core which writes the entries to ws with the encoder and the level of the config
+func newOutputCore(cfg *Config, ws zapcore.WriteSyncer) (*textIOCore, error) {
+	level := zap.NewAtomicLevel()
+	err := level.UnmarshalText([]byte(cfg.Level))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	enc, err := newZapEncoder(cfg, ws)
+	if err != nil {
+		return nil, err
+	}
+
+	core := NewTextCore(enc, ws, level).(*textIOCore)
+	if cfg.Dedup != nil {
+		// the error output is set to the output of the logger by addOutput()
+		core.dedup = newDeduper(cfg.Dedup, nil)
+	}
+
+	return core, nil
+}
+
+// newOutputCores returns the cores of Config.Outputs, the log files are opened by lumberjack
+func newOutputCores(cfg *Config) ([]*textIOCore, error) {
+	var cores []*textIOCore
+	for _, oc := range cfg.Outputs {
+		ws := NewStdoutWriteSyncer()
+		if oc.File.FileName != "" {
+			writer, err := InitLumberjackLoggerWithFileLogConfig(&oc.File)
+			if err != nil {
+				_ = closeOutputCores(cores)
+				return nil, err
+			}
+			ws = NewWriteSyncer(writer)
+		}
+		core, err := newOutputCore(oc.config(cfg), ws)
+		if err != nil {
+			_ = closeWriteSyncers([]zapcore.WriteSyncer{ws})
+			_ = closeOutputCores(cores)
+			return nil, err
+		}
+		cores = append(cores, core)
+	}
+
+	return cores, nil
+}
+
+// closeOutputCores closes the lumberjack writers of the cores, it is called if the logger could not be initialized
+func closeOutputCores(cores []*textIOCore) error {
+	var err *multierror.Error
+	for _, core := range cores {
+		err = multierror.Append(err, closeWriteSyncers(core.ListWriteSyncer()))
+	}
+
+	return err.ErrorOrNil()
+}
+
+// outputSet is a list of the outputs, it is never changed after it is stored in sharedOutputs
+type outputSet struct {
+	// base is the set which the outputs are derived from by With(), it is nil for the added outputs
+	base  *outputSet
+	cores []*textIOCore
+}
+
+// sharedOutputs holds the outputs added to a core, it is shared by the clones of the core,
+// so the outputs added after With() reach the child loggers, the set is a snapshot like sharedTextSettings
+type sharedOutputs struct {
+	snapshot
+}
+
+// newSharedOutputs returns a *sharedOutputs without any output
+func newSharedOutputs() *sharedOutputs {
+	s := &sharedOutputs{}
+	s.store(&outputSet{})
+
+	return s
+}
+
+// load returns the latest outputs, they must not be changed
+func (s *sharedOutputs) load() *outputSet {
+	return s.snapshot.load().(*outputSet)
+}
+
+// copied returns a new *sharedOutputs with the same outputs, the outputs added to it later do not reach s
+func (s *sharedOutputs) copied() *sharedOutputs {
+	cp := &sharedOutputs{}
+	cp.store(s.load())
+
+	return cp
+}
+
+// add appends the output to a copy of the set and swaps it in
+func (s *sharedOutputs) add(output *textIOCore) {
+	s.update(func(value interface{}) interface{} {
+		old := value.(*outputSet)
+		cores := make([]*textIOCore, len(old.cores), len(old.cores)+1)
+		copy(cores, old.cores)
+		return &outputSet{cores: append(cores, output)}
+	})
+}
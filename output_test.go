@@ -0,0 +1,192 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newOutputTestLogger returns a text logger which writes to b without the time and the caller
+func newOutputTestLogger(t *testing.T, b *lockedBuilder) *Logger {
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Encoder.TimeKey = EncoderKeyOmit
+	cfg.DisableCaller = true
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(b))
+	assert.Nil(t, err, "init logger failed")
+
+	return NewMyLogger(zapLogger)
+}
+
+// newOutputTestConfig returns the config of an output without the time and the caller
+func newOutputTestConfig(level, format string) *Config {
+	cfg := NewConfigWithStdout(level, format)
+	cfg.Encoder.TimeKey = EncoderKeyOmit
+	cfg.DisableCaller = true
+
+	return cfg
+}
+
+func TestOutputs(t *testing.T) {
+	asst := assert.New(t)
+
+	cases := []struct {
+		name     string
+		level    string
+		format   string
+		expected string
+	}{
+		{"json above warn", "warn", LogFormatJSON, `{"level":"WARN","message":"warn message","service":"log"}` + "\n"},
+		{"text all levels", "info", LogFormatText, "[INFO][\"info message\"] [service=log]\n[WARN][\"warn message\"] [service=log]\n"},
+		{"json above error", "error", LogFormatJSON, ""},
+	}
+	for _, c := range cases {
+		var text, output lockedBuilder
+		logger := newOutputTestLogger(t, &text)
+		asst.Nil(logger.AddWriteSyncerWithConfig(zapcore.AddSync(&output), newOutputTestConfig(c.level, c.format)), "add output failed")
+
+		child := logger.WithOptions(zap.Fields(zap.String("service", "log")))
+		child.Info("info message")
+		child.Warn("warn message")
+		asst.Equal("[INFO][\"info message\"] [service=log]\n[WARN][\"warn message\"] [service=log]\n", text.String(), c.name)
+		asst.Equal(c.expected, output.String(), c.name)
+	}
+
+	logger := newOutputTestLogger(t, &lockedBuilder{})
+	err := logger.AddWriteSyncerWithConfig(zapcore.AddSync(&lockedBuilder{}), NewConfigWithStdout("wrong", LogFormatJSON))
+	asst.NotNil(err, "invalid level should fail")
+}
+
+func TestOutputsAddedAfterWith(t *testing.T) {
+	asst := assert.New(t)
+
+	var text, output lockedBuilder
+	logger := newOutputTestLogger(t, &text)
+	child := NewMyLogger(logger.zapLogger.With(zap.String("service", "log")))
+	grandchild := NewMyLogger(child.zapLogger.With(zap.Int("pid", 1)))
+	asst.Nil(logger.AddWriteSyncerWithConfig(zapcore.AddSync(&output), newOutputTestConfig("info", LogFormatText)), "add output failed")
+
+	// the output reaches the clones made before it is added, with the fields of the clones
+	cases := []struct {
+		logger   *Logger
+		message  string
+		expected string
+	}{
+		{logger, "root", "[INFO][root]\n"},
+		{child, "child", "[INFO][child] [service=log]\n"},
+		{grandchild, "grandchild", "[INFO][grandchild] [service=log][pid=1]\n"},
+	}
+	expected := ""
+	for _, c := range cases {
+		c.logger.Info(c.message)
+		expected += c.expected
+		asst.Equal(expected, output.String())
+	}
+	asst.Equal(expected, text.String(), "the logger and the output should have the same entries")
+}
+
+func TestOutputsAddedToClone(t *testing.T) {
+	asst := assert.New(t)
+
+	var text, loggerOutput, cloneOutput lockedBuilder
+	logger := newOutputTestLogger(t, &text)
+	asst.Nil(logger.AddWriteSyncerWithConfig(zapcore.AddSync(&loggerOutput), newOutputTestConfig("info", LogFormatText)), "add output failed")
+	clone := CloneLogger(logger)
+	child := NewMyLogger(clone.zapLogger.With(zap.String("service", "log")))
+	asst.Nil(clone.AddWriteSyncerWithConfig(zapcore.AddSync(&cloneOutput), newOutputTestConfig("info", LogFormatText)), "add output failed")
+
+	// the output added to the clone reaches the child of the clone, but not the logger
+	logger.Info("logger")
+	clone.Info("clone")
+	child.Info("child")
+	asst.Equal("[INFO][logger]\n[INFO][clone]\n[INFO][child] [service=log]\n", loggerOutput.String())
+	asst.Equal("[INFO][clone]\n[INFO][child] [service=log]\n", cloneOutput.String())
+}
+
+func TestOutputsConcurrentAdd(t *testing.T) {
+	asst := assert.New(t)
+
+	logger := newOutputTestLogger(t, &lockedBuilder{})
+	child := NewMyLogger(logger.zapLogger.With(zap.String("service", "log")))
+	outputs := make([]lockedBuilder, 4)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range outputs {
+			_ = logger.AddWriteSyncerWithConfig(zapcore.AddSync(&outputs[i]), newOutputTestConfig("info", LogFormatText))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			child.Info("message")
+		}
+	}()
+	wg.Wait()
+
+	child.Info("last")
+	for i := range outputs {
+		asst.True(strings.HasSuffix(outputs[i].String(), "[INFO][last] [service=log]\n"), "all the outputs should be written")
+	}
+}
+
+func TestOutputFiles(t *testing.T) {
+	asst := assert.New(t)
+
+	dir, err := os.MkdirTemp("", "log-outputs")
+	asst.Nil(err, "create temp dir failed")
+	defer os.RemoveAll(dir)
+
+	// the outputs declared in config write to their own files
+	var text lockedBuilder
+	cfg := newOutputTestConfig(DefaultLogLevel, LogFormatText)
+	cfg.Outputs = []OutputConfig{{
+		Format: LogFormatJSON,
+		File:   FileLogConfig{FileName: filepath.Join(dir, "log.json")},
+	}}
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&text))
+	asst.Nil(err, "init logger failed")
+	logger := NewMyLogger(zapLogger)
+	logger.Info("file message")
+	content, err := os.ReadFile(filepath.Join(dir, "log.json"))
+	asst.Nil(err, "read log file failed")
+	asst.Equal(`{"level":"INFO","message":"file message"}`+"\n", string(content))
+	asst.Equal("[INFO][\"file message\"]\n", text.String())
+
+	// the log file of the output is rotated even if the logger writes to a writer which is not a file
+	asst.Nil(logger.Rotate(), "rotate failed")
+	files, err := os.ReadDir(dir)
+	asst.Nil(err, "read dir failed")
+	asst.Equal(2, len(files), "log file should be rotated")
+	asst.Nil(logger.Sync(), "sync failed")
+}
+
+func TestCloseOutputCores(t *testing.T) {
+	asst := assert.New(t)
+
+	dir, err := os.MkdirTemp("", "log-outputs")
+	asst.Nil(err, "create temp dir failed")
+	defer os.RemoveAll(dir)
+
+	cfg := newOutputTestConfig(DefaultLogLevel, LogFormatText)
+	cfg.Outputs = []OutputConfig{{File: FileLogConfig{FileName: filepath.Join(dir, "output.log")}}}
+	outputs, err := newOutputCores(cfg)
+	asst.Nil(err, "create outputs failed")
+	asst.Nil(outputs[0].Write(zapcore.Entry{Message: "message"}, nil), "write failed")
+	writer := outputs[0].out.(*WriteSyncer).GetWriter().(*Writer)
+	asst.NotNil(writer.file, "log file should be opened")
+	asst.Nil(closeOutputCores(outputs), "close outputs failed")
+	asst.Nil(writer.file, "log file should be closed")
+
+	// the outputs are closed if the routes could not be created
+	cfg.Routes = []RouteConfig{{MinLevel: "error", MaxLevel: "info", File: FileLogConfig{FileName: filepath.Join(dir, "route.log")}}}
+	_, _, err = InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(&lockedBuilder{}))
+	asst.NotNil(err, "invalid level range should fail")
+}
@@ -0,0 +1,629 @@
+package log
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	ErrNotSupportedByParser = "%s is not supported by text parser."
+	ErrInvalidTextEntry     = "invalid text entry at position %d, %s."
+
+	// errIncompleteTextEntry means the entry ends inside an item, the rest of it may be in the next lines
+	errIncompleteTextEntry = errors.New("incomplete text entry")
+)
+
+// newErrInvalidTextEntry returns an error of the invalid text entry
+func newErrInvalidTextEntry(pos int, reason string) error {
+	return errors.New(fmt.Sprintf(ErrInvalidTextEntry, pos, reason))
+}
+
+// textItem is a bracketed item of the text entries, which is either a header value or a key=value field
+type textItem struct {
+	key    string
+	value  string
+	hasKey bool
+	// quoted is true if the value is wrapped with double quotes
+	quoted bool
+}
+
+// textCursor reads the items of a text entry
+type textCursor struct {
+	s   string
+	pos int
+	// disableEscape means the double quotes and the line breaks in the values are not escaped
+	disableEscape bool
+}
+
+// peek returns true if the next byte is b
+func (c *textCursor) peek(b byte) bool {
+	return c.pos < len(c.s) && c.s[c.pos] == b
+}
+
+// hasPrefix returns true if the rest of the entry starts with prefix
+func (c *textCursor) hasPrefix(prefix string) bool {
+	return strings.HasPrefix(c.s[c.pos:], prefix)
+}
+
+// readItem reads a bracketed item, the cursor must be at the opening bracket
+func (c *textCursor) readItem() (textItem, error) {
+	var item textItem
+	c.pos++
+	token, quoted, err := c.readToken(true)
+	if err != nil {
+		return item, err
+	}
+	if c.peek('=') {
+		c.pos++
+		item.key, item.hasKey = token, true
+		token, quoted, err = c.readToken(false)
+		if err != nil {
+			return item, err
+		}
+	}
+	item.value, item.quoted = token, quoted
+	if c.pos >= len(c.s) {
+		return item, errIncompleteTextEntry
+	}
+	if c.s[c.pos] != ']' {
+		return item, newErrInvalidTextEntry(c.pos, fmt.Sprintf("expect ] but got %q", c.s[c.pos]))
+	}
+	c.pos++
+
+	return item, nil
+}
+
+// readToken reads a quoted or an unquoted token and unescapes it, the key token ends with = or ],
+// and the value token ends with ], the brackets in the unquoted token must be balanced,
+// as the arrays are not quoted if DisableDoubleQuotes is true
+func (c *textCursor) readToken(key bool) (string, bool, error) {
+	if c.peek('"') {
+		c.pos++
+		start := c.pos
+		for c.pos < len(c.s) {
+			switch c.s[c.pos] {
+			case '\\':
+				c.pos += 2
+				continue
+			case '"':
+				if !c.disableEscape || c.closesQuote(key) {
+					token := unescapeText(c.s[start:c.pos])
+					c.pos++
+					return token, true, nil
+				}
+			}
+			c.pos++
+		}
+		return "", false, errIncompleteTextEntry
+	}
+
+	start, depth := c.pos, 0
+	for c.pos < len(c.s) {
+		switch c.s[c.pos] {
+		case '\\':
+			c.pos += 2
+			continue
+		case '=':
+			if key && depth == 0 {
+				return unescapeText(c.s[start:c.pos]), false, nil
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				return unescapeText(c.s[start:c.pos]), false, nil
+			}
+			depth--
+		}
+		c.pos++
+	}
+
+	return "", false, errIncompleteTextEntry
+}
+
+// closesQuote returns true if the double quote at the cursor closes the token,
+// it is used if DisableEscape is true, as the double quotes in the values are not escaped,
+// so only the double quote followed by the end of the item closes it,
+// the quoted elements at the end of the quoted arrays are followed by ]" or ], or ]]
+func (c *textCursor) closesQuote(key bool) bool {
+	if c.pos+1 >= len(c.s) {
+		return false
+	}
+	next := c.s[c.pos+1]
+	if key && next == '=' {
+		return true
+	}
+	if next != ']' {
+		return false
+	}
+	if c.pos+2 == len(c.s) {
+		return true
+	}
+	switch c.s[c.pos+2] {
+	case '"', ',', ']':
+		return false
+	default:
+		return true
+	}
+}
+
+// unescapeText reverses the escaping of textEncoder.safeAddString(),
+// the invalid utf-8 bytes are replaced with \ufffd by the encoder, so they could not be restored
+func unescapeText(s string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	buf := _pool.Get()
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b != '\\' || i+1 == len(s) {
+			buf.AppendByte(b)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			buf.AppendByte('\n')
+		case 'r':
+			buf.AppendByte('\r')
+		case 't':
+			buf.AppendByte('\t')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					buf.AppendString(string(rune(r)))
+					i += 4
+					break
+				}
+			}
+			buf.AppendString(`\u`)
+		default:
+			// \\ and \"
+			buf.AppendByte(s[i])
+		}
+	}
+	s = buf.String()
+	buf.Free()
+
+	return s
+}
+
+// stripColor removes the ANSI color codes of the colorized levels
+func stripColor(s string) string {
+	if strings.IndexByte(s, '\x1b') < 0 {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\x1b' {
+			for i < len(s) && s[i] != 'm' {
+				i++
+			}
+			continue
+		}
+		sb.WriteByte(s[i])
+	}
+
+	return sb.String()
+}
+
+// Parser parses the entries encoded by the text encoder with the default bracketed layout
+// back into the entries and the fields, it understands the quoting and the escaping of the encoder,
+// including DisableDoubleQuotes and DisableEscape, the fields are parsed as string fields,
+// except the quoted values are parsed as byte string fields if DisableDoubleQuotes is true,
+// so encoding the parsed entry with the same config outputs the same line.
+// The output is ambiguous in a few cases, which are not parsed as they were encoded:
+// the unquoted message containing = or unbalanced brackets and the unquoted field values containing unbalanced brackets
+// or being { if DisableDoubleQuotes is true,
+// the quoted values containing "] or "= if DisableEscape is true, and the logger name without message.
+type Parser struct {
+	enc *textEncoder
+}
+
+// NewParser returns a *Parser which parses the entries encoded with the config,
+// the pattern layout and the other formats are not supported
+func NewParser(cfg *Config) (*Parser, error) {
+	if format := strings.ToLower(cfg.Format); format != "" && format != LogFormatText {
+		return nil, errors.New(fmt.Sprintf(ErrNotSupportedByParser, "format "+cfg.Format))
+	}
+	if cfg.Layout != "" {
+		return nil, errors.New(fmt.Sprintf(ErrNotSupportedByParser, "layout"))
+	}
+	_, err := newTimeLocation(cfg.TimeZone)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf(ErrInvalidTimeZone, cfg.TimeZone, err.Error()))
+	}
+
+	return &Parser{enc: newTextEncoder(cfg, nil)}, nil
+}
+
+// Parse parses a line encoded by the text encoder, the line ending is optional
+func (p *Parser) Parse(line string) (zapcore.Entry, []zapcore.Field, error) {
+	ent, fields, err := p.parse(line)
+	if err == errIncompleteTextEntry {
+		return ent, nil, newErrInvalidTextEntry(len(line), "unexpected end of entry")
+	}
+
+	return ent, fields, err
+}
+
+// parse parses the entry, errIncompleteTextEntry is returned if the entry ends inside an item
+func (p *Parser) parse(line string) (zapcore.Entry, []zapcore.Field, error) {
+	var (
+		ent  zapcore.Entry
+		item textItem
+		err  error
+	)
+	// the settings could be changed at runtime, the entry is parsed with the latest snapshot of them
+	enc := p.enc.shared.load()
+	c := &textCursor{s: strings.TrimRight(line, "\r\n"), disableEscape: enc.DisableEscape}
+
+	if enc.TimeKey != "" {
+		item, err = p.readHeaderItem(c, "time")
+		if err != nil {
+			return ent, nil, err
+		}
+		ent.Time, err = p.parseTime(item.value, enc)
+		if err != nil {
+			return ent, nil, newErrInvalidTextEntry(c.pos, err.Error())
+		}
+	}
+	if enc.CallerKey != "" && c.peek('[') {
+		// the caller is omitted if it is not defined
+		pos := c.pos
+		item, err = c.readItem()
+		if err != nil {
+			return ent, nil, err
+		}
+		caller, ok := parseTextCaller(item)
+		if ok {
+			ent.Caller = caller
+		} else {
+			c.pos = pos
+		}
+	}
+	if ent.Caller.Defined && enc.FunctionKey != "" {
+		item, err = p.readHeaderItem(c, "function")
+		if err != nil {
+			return ent, nil, err
+		}
+		ent.Caller.Function = item.value
+	}
+	if enc.LevelKey != "" {
+		item, err = p.readHeaderItem(c, "level")
+		if err != nil {
+			return ent, nil, err
+		}
+		err = ent.Level.UnmarshalText([]byte(stripColor(item.value)))
+		if err != nil {
+			return ent, nil, newErrInvalidTextEntry(c.pos, err.Error())
+		}
+	}
+	err = p.parseNameAndMessage(c, &ent, enc)
+	if err != nil {
+		return ent, nil, err
+	}
+	fields, err := p.parseFields(c, &ent, enc)
+	if err != nil {
+		return ent, nil, err
+	}
+	if c.pos < len(c.s) {
+		return ent, nil, newErrInvalidTextEntry(c.pos, fmt.Sprintf("unexpected %q", c.s[c.pos]))
+	}
+
+	return ent, fields, nil
+}
+
+// readHeaderItem reads an item of the header, which must not be a field
+func (p *Parser) readHeaderItem(c *textCursor, name string) (textItem, error) {
+	if c.pos >= len(c.s) {
+		return textItem{}, errIncompleteTextEntry
+	}
+	if !c.peek('[') {
+		return textItem{}, newErrInvalidTextEntry(c.pos, "expect "+name)
+	}
+	item, err := c.readItem()
+	if err != nil {
+		return item, err
+	}
+	if item.hasKey {
+		return item, newErrInvalidTextEntry(c.pos, "expect "+name+" but got a field")
+	}
+
+	return item, nil
+}
+
+// parseNameAndMessage parses the logger name, the seperator and the message,
+// the name and the message are omitted if they are empty
+func (p *Parser) parseNameAndMessage(c *textCursor, ent *zapcore.Entry, enc *textSettings) error {
+	texts, err := p.readTexts(c, 2, enc.Seperator)
+	if err != nil {
+		return err
+	}
+	if enc.Seperator != "" && c.hasPrefix(enc.Seperator) {
+		// the items before the seperator are the name
+		if len(texts) > 1 {
+			return newErrInvalidTextEntry(c.pos, "expect "+enc.Seperator)
+		}
+		if len(texts) == 1 {
+			ent.LoggerName = texts[0]
+		}
+		c.pos += len(enc.Seperator)
+		texts, err = p.readTexts(c, 1, enc.Seperator)
+		if err != nil {
+			return err
+		}
+		if len(texts) == 1 {
+			ent.Message = texts[0]
+		}
+		return nil
+	}
+
+	switch {
+	case len(texts) == 2:
+		ent.LoggerName, ent.Message = texts[0], texts[1]
+	case len(texts) == 1 && enc.MessageKey != "":
+		ent.Message = texts[0]
+	case len(texts) == 1:
+		ent.LoggerName = texts[0]
+	}
+
+	return nil
+}
+
+// readTexts reads at most n items which are not fields, it stops at the seperator
+func (p *Parser) readTexts(c *textCursor, n int, seperator string) ([]string, error) {
+	var texts []string
+	for len(texts) < n && c.peek('[') {
+		pos := c.pos
+		item, err := c.readItem()
+		if err != nil {
+			return nil, err
+		}
+		if item.hasKey {
+			c.pos = pos
+			break
+		}
+		texts = append(texts, item.value)
+		if seperator != "" && c.hasPrefix(seperator) {
+			break
+		}
+	}
+
+	return texts, nil
+}
+
+// parseFields parses the fields as string fields, the namespaces are parsed as namespace fields,
+// and the stack which is the last field is parsed into the entry
+func (p *Parser) parseFields(c *textCursor, ent *zapcore.Entry, enc *textSettings) ([]zapcore.Field, error) {
+	var fields []zapcore.Field
+	// the fields added by With() are separated from the header by a space
+	if c.peek(' ') {
+		c.pos++
+	}
+	namespaces := 0
+	for c.peek('[') {
+		item, err := c.readItem()
+		if err != nil {
+			return nil, err
+		}
+		if !item.hasKey {
+			return nil, newErrInvalidTextEntry(c.pos, "expect a field")
+		}
+		if namespaces == 0 && p.isStack(c, item, enc) {
+			ent.Stack = p.unwrapStack(item.value, enc)
+			return fields, nil
+		}
+		if !item.quoted && item.value == "{" {
+			fields = append(fields, zap.Namespace(item.key))
+			namespaces++
+			continue
+		}
+		if item.quoted && enc.DisableDoubleQuotes {
+			// the arrays, the objects and the byte strings are quoted even if DisableDoubleQuotes is true
+			fields = append(fields, zap.ByteString(item.key, []byte(item.value)))
+			continue
+		}
+		fields = append(fields, zap.String(item.key, item.value))
+	}
+	for ; namespaces > 0; namespaces-- {
+		if !c.peek('}') {
+			return nil, newErrInvalidTextEntry(c.pos, "expect }")
+		}
+		c.pos++
+	}
+	if c.peek('[') {
+		// the stack is added after closing the namespaces
+		item, err := c.readItem()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isStack(c, item, enc) {
+			return nil, newErrInvalidTextEntry(c.pos, "expect "+enc.StacktraceKey)
+		}
+		ent.Stack = p.unwrapStack(item.value, enc)
+	}
+
+	return fields, nil
+}
+
+// isStack returns true if the item is the stack, which is the last item of the entry
+func (p *Parser) isStack(c *textCursor, item textItem, enc *textSettings) bool {
+	return enc.StacktraceKey != "" && item.key == enc.StacktraceKey && c.pos == len(c.s)
+}
+
+// unwrapStack removes the line breaks which wrap the stack if DisableEscape is true
+func (p *Parser) unwrapStack(stack string, enc *textSettings) string {
+	if enc.DisableEscape {
+		stack = strings.TrimSuffix(strings.TrimPrefix(stack, "\n"), "\n")
+	}
+
+	return stack
+}
+
+// parseTime parses the time with the time format and the time zone of the encoder
+func (p *Parser) parseTime(s string, enc *textSettings) (time.Time, error) {
+	format := enc.TimeFormat
+	if format == "" {
+		format = defaultLogTimeFormat
+	}
+	switch format {
+	case TimeFormatUnix, TimeFormatUnixMilli, TimeFormatUnixMicro:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		switch format {
+		case TimeFormatUnix:
+			return time.Unix(n, 0), nil
+		case TimeFormatUnixMilli:
+			return time.Unix(0, n*int64(time.Millisecond)), nil
+		default:
+			return time.Unix(0, n*int64(time.Microsecond)), nil
+		}
+	}
+	loc := enc.timeLocation
+	if loc == nil {
+		loc = time.Local
+	}
+
+	return time.ParseInLocation(format, s, loc)
+}
+
+// parseTextCaller parses the caller in file:line format, false is returned if the item is not a caller
+func parseTextCaller(item textItem) (zapcore.EntryCaller, bool) {
+	if item.hasKey {
+		return zapcore.EntryCaller{}, false
+	}
+	idx := strings.LastIndexByte(item.value, ':')
+	if idx <= 0 {
+		return zapcore.EntryCaller{}, false
+	}
+	line, err := strconv.Atoi(item.value[idx+1:])
+	if err != nil {
+		return zapcore.EntryCaller{}, false
+	}
+
+	return zapcore.EntryCaller{Defined: true, File: item.value[:idx], Line: line}, true
+}
+
+// Scanner reads the entries encoded by the text encoder one by one, like bufio.Scanner,
+// the entries spanning several lines because of DisableEscape are read as a whole,
+// and the structured stack frames, which are the indented lines after the entry, are joined into Entry.Stack
+type Scanner struct {
+	parser *Parser
+	reader *bufio.Reader
+	// pending is the line which has been read ahead, it is the beginning of the next entry
+	pending    string
+	hasPending bool
+
+	entry  zapcore.Entry
+	fields []zapcore.Field
+	err    error
+}
+
+// NewScanner returns a *Scanner which reads the entries encoded with the config from r
+func NewScanner(r io.Reader, cfg *Config) (*Scanner, error) {
+	parser, err := NewParser(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scanner{parser: parser, reader: bufio.NewReader(r)}, nil
+}
+
+// Scan advances to the next entry, it returns false when the scan stops,
+// either by reaching the end of the input or an error
+func (s *Scanner) Scan() bool {
+	if s.err != nil {
+		return false
+	}
+	line, ok := s.readLine()
+	for ok && line == "" {
+		line, ok = s.readLine()
+	}
+	if !ok {
+		return false
+	}
+	for {
+		ent, fields, err := s.parser.parse(line)
+		if err == errIncompleteTextEntry {
+			next, ok := s.readLine()
+			if ok {
+				line += "\n" + next
+				continue
+			}
+			if s.err != nil {
+				return false
+			}
+			err = newErrInvalidTextEntry(len(line), "unexpected end of entry")
+		}
+		if err != nil {
+			s.err = err
+			return false
+		}
+		frames := s.readFrames()
+		if len(frames) > 0 {
+			ent.Stack = strings.Join(frames, "\n")
+		}
+		s.entry, s.fields = ent, fields
+		return true
+	}
+}
+
+// readFrames reads the indented lines of the structured stack frames
+func (s *Scanner) readFrames() []string {
+	var frames []string
+	for {
+		line, ok := s.readLine()
+		if !ok {
+			return frames
+		}
+		if !strings.HasPrefix(line, consoleIndent) {
+			s.pending, s.hasPending = line, true
+			return frames
+		}
+		frames = append(frames, unescapeText(strings.TrimRight(line[len(consoleIndent):], "\r")))
+	}
+}
+
+// readLine returns the next line without the line break, false is returned at the end of the input or on error
+func (s *Scanner) readLine() (string, bool) {
+	if s.hasPending {
+		s.hasPending = false
+		return s.pending, true
+	}
+	line, err := s.reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		s.err = err
+		return "", false
+	}
+	if err == io.EOF && line == "" {
+		return "", false
+	}
+
+	return strings.TrimSuffix(line, "\n"), true
+}
+
+// Entry returns the entry parsed by the last call of Scan()
+func (s *Scanner) Entry() zapcore.Entry {
+	return s.entry
+}
+
+// Fields returns the fields parsed by the last call of Scan()
+func (s *Scanner) Fields() []zapcore.Field {
+	return s.fields
+}
+
+// Err returns the first error of the scanner
+func (s *Scanner) Err() error {
+	return s.err
+}
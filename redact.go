@@ -0,0 +1,128 @@
+package log
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pingcap/errors"
+)
+
+const (
+	// RedactModeMask replaces the values with the mask
+	RedactModeMask = "mask"
+	// RedactModeHash replaces the values with the salted sha256 hash,
+	// so that the same values could still be correlated
+	RedactModeHash = "hash"
+
+	DefaultRedactMask = "***"
+
+	redactHashPrefix = "sha256:"
+	// redactHashBytes is the number of bytes of the hash which are kept
+	redactHashBytes = 8
+)
+
+var (
+	ErrInvalidRedactKey  = "invalid redact key %s, %s."
+	ErrInvalidRedactMode = "invalid redact mode %s, must be either mask or hash."
+
+	// DefaultRedactKeys is a deny-list which covers the most common sensitive keys
+	DefaultRedactKeys = []string{"*password*", "*passwd*", "*secret*", "*token*", "authorization", "cookie"}
+)
+
+// RedactConfig serializes redaction related config in yaml/json.
+type RedactConfig struct {
+	// Keys are the exact names or the glob patterns of the keys, like *password*,
+	// the values of matching keys will be redacted, the matching is case-insensitive,
+	// the keys inside the reflected values like the fields of the structs and the keys of the maps
+	// logged by zap.Reflect() and zap.Any() are not matched, log them with zap.Object() to redact them.
+	Keys []string `yaml:"keys" json:"keys"`
+	// Mode is one of mask or hash, default is mask.
+	Mode string `yaml:"mode" json:"mode"`
+	// Mask replaces the values in mask mode, default is ***.
+	Mask string `yaml:"mask" json:"mask"`
+	// Salt is prepended to the values before hashing in hash mode.
+	Salt string `yaml:"salt" json:"salt"`
+}
+
+// validate validates the redact config
+func (rc RedactConfig) validate() error {
+	for _, key := range rc.Keys {
+		_, err := path.Match(key, "")
+		if err != nil {
+			return errors.New(fmt.Sprintf(ErrInvalidRedactKey, key, err.Error()))
+		}
+	}
+	mode := strings.ToLower(rc.Mode)
+	if mode != "" && mode != RedactModeMask && mode != RedactModeHash {
+		return errors.New(fmt.Sprintf(ErrInvalidRedactMode, rc.Mode))
+	}
+
+	return nil
+}
+
+// redactor replaces the values of the sensitive keys
+type redactor struct {
+	keys     map[string]struct{}
+	patterns []string
+	hash     bool
+	mask     string
+	salt     string
+}
+
+// newRedactor returns a *redactor, it returns nil if there is no key to redact
+func newRedactor(rc RedactConfig) *redactor {
+	if len(rc.Keys) == 0 {
+		return nil
+	}
+
+	r := &redactor{
+		keys: make(map[string]struct{}),
+		hash: strings.ToLower(rc.Mode) == RedactModeHash,
+		mask: rc.Mask,
+		salt: rc.Salt,
+	}
+	if r.mask == "" {
+		r.mask = DefaultRedactMask
+	}
+	for _, key := range rc.Keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if strings.ContainsAny(key, "*?[") {
+			r.patterns = append(r.patterns, key)
+			continue
+		}
+		r.keys[key] = struct{}{}
+	}
+
+	return r
+}
+
+// match returns true if the key is in the deny-list
+func (r *redactor) match(key string) bool {
+	key = strings.ToLower(key)
+	_, ok := r.keys[key]
+	if ok {
+		return true
+	}
+	for _, pattern := range r.patterns {
+		matched, _ := path.Match(pattern, key)
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redact returns the mask, or the salted hash of the value in hash mode
+func (r *redactor) redact(val string) string {
+	if !r.hash {
+		return r.mask
+	}
+
+	sum := sha256.Sum256([]byte(r.salt + val))
+
+	return redactHashPrefix + hex.EncodeToString(sum[:redactHashBytes])
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"fmt"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	ErrInvalidLevelRange = "invalid level range of route %s, min level %s is larger than max level %s."
+)
+
+// RouteConfig serializes the config of a route in yaml/json, the route writes the entries
+// with the levels in [MinLevel, MaxLevel] to its own log file, which is rotated by its own lumberjack writer,
+// the format and the encoder options are the same as the logger.
+type RouteConfig struct {
+	// MinLevel is the minimum level of the route, empty level is inherited from the config of the logger.
+	MinLevel string `yaml:"min-level" json:"min-level"`
+	// MaxLevel is the maximum level of the route, empty level means no upper bound.
+	MaxLevel string `yaml:"max-level" json:"max-level"`
+	// File is the log file of the route.
+	File FileLogConfig `yaml:"file" json:"file"`
+}
+
+// NewRouteConfig returns a *RouteConfig which writes the entries with the levels in [minLevel, maxLevel] to the file
+func NewRouteConfig(minLevel, maxLevel, fileName string, maxSize, maxDays, maxBackups int, options ...Option) (*RouteConfig, error) {
+	fileCfg, err := NewFileLogConfig(fileName, maxSize, maxDays, maxBackups, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RouteConfig{
+		MinLevel: minLevel,
+		MaxLevel: maxLevel,
+		File:     *fileCfg,
+	}, nil
+}
+
+// levelRange enables the levels which are enabled by min and not larger than max
+type levelRange struct {
+	min zapcore.LevelEnabler
+	max zapcore.Level
+}
+
+// Enabled returns true if the level is in the range
+func (r levelRange) Enabled(level zapcore.Level) bool {
+	return r.min.Enabled(level) && level <= r.max
+}
+
+// newRouteCore returns a core which writes the entries in the level range of the route to ws
+func newRouteCore(cfg *Config, rc RouteConfig, ws zapcore.WriteSyncer) (*textIOCore, error) {
+	c := OutputConfig{
+		Level:               rc.MinLevel,
+		DisableDoubleQuotes: cfg.DisableDoubleQuotes,
+		DisableEscape:       cfg.DisableEscape,
+		File:                rc.File,
+	}.config(cfg)
+	core, err := newOutputCore(c, ws)
+	if err != nil {
+		return nil, err
+	}
+	if rc.MaxLevel == "" {
+		return core, nil
+	}
+
+	max := zap.NewAtomicLevel()
+	err = max.UnmarshalText([]byte(rc.MaxLevel))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if !core.LevelEnabler.Enabled(max.Level()) {
+		return nil, errors.New(fmt.Sprintf(ErrInvalidLevelRange, rc.File.FileName, c.Level, rc.MaxLevel))
+	}
+	core.LevelEnabler = levelRange{min: core.LevelEnabler, max: max.Level()}
+
+	return core, nil
+}
+
+// newRouteCores returns the cores of Config.Routes, the log files are opened by lumberjack
+func newRouteCores(cfg *Config) ([]*textIOCore, error) {
+	var cores []*textIOCore
+	for _, rc := range cfg.Routes {
+		if rc.File.FileName == "" {
+			_ = closeOutputCores(cores)
+			return nil, errors.New(ErrEmptyLogFileName)
+		}
+		writer, err := InitLumberjackLoggerWithFileLogConfig(&rc.File)
+		if err != nil {
+			_ = closeOutputCores(cores)
+			return nil, err
+		}
+		core, err := newRouteCore(cfg, rc, NewWriteSyncer(writer))
+		if err != nil {
+			_ = writer.Close()
+			_ = closeOutputCores(cores)
+			return nil, err
+		}
+		cores = append(cores, core)
+	}
+
+	return cores, nil
+}
@@ -0,0 +1,502 @@
+package log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	minSamplingLevel    = zapcore.DebugLevel
+	maxSamplingLevel    = zapcore.FatalLevel
+	numSamplingLevels   = maxSamplingLevel - minSamplingLevel + 1
+	countersPerLevel    = 4096
+	fnvOffset32         = 2166136261
+	fnvPrime32          = 16777619
+	defaultSamplingTick = time.Second
+
+	// SamplingReportMessage is the message of the entry which reports the numbers of the sampled and the dropped entries
+	SamplingReportMessage = "sampling report"
+	// SamplingSampledSuffix is the suffix of the keys of the numbers of the sampled entries in the report, like info-sampled
+	SamplingSampledSuffix = "-sampled"
+	// SamplingDroppedSuffix is the suffix of the keys of the numbers of the dropped entries in the report, like info-dropped
+	SamplingDroppedSuffix = "-dropped"
+
+	// the indexes of the decisions in the stats
+	samplingDropped = 0
+	samplingSampled = 1
+)
+
+var (
+	ErrInvalidSamplingRule = "invalid sampling rule %d, %s."
+	ErrNotSampled          = "make sure the core of the logger is sampled by the sampler of this package"
+)
+
+// SamplingRule is the sampling rule of the levels in [MinLevel, MaxLevel],
+// the first Initial entries with the same message in each tick are logged, and every Thereafter-th entry after that.
+type SamplingRule struct {
+	// MinLevel is the minimum level of the rule, empty level means debug.
+	MinLevel string `yaml:"min-level" json:"min-level"`
+	// MaxLevel is the maximum level of the rule, empty level means fatal.
+	MaxLevel   string `yaml:"max-level" json:"max-level"`
+	Initial    int    `yaml:"initial" json:"initial"`
+	Thereafter int    `yaml:"thereafter" json:"thereafter"`
+	// Disable disables sampling the levels, all the entries of them are logged.
+	Disable bool `yaml:"disable" json:"disable"`
+}
+
+// SamplerConfig serializes the config of the sampler in yaml/json, it supersedes Config.Sampling.
+// The rules are applied in order, the later rules override the former ones, the levels without rule are not sampled.
+type SamplerConfig struct {
+	// Tick is the interval in which the entries are counted, default is one second.
+	Tick time.Duration `yaml:"tick" json:"tick"`
+	// Rules are the sampling rules of the levels.
+	Rules []SamplingRule `yaml:"rules" json:"rules"`
+	// Key is the key of the field by which the entries are sampled, for example: user_id,
+	// the entries with the same message and different values of the field are counted separately.
+	Key string `yaml:"key" json:"key"`
+	// ReportInterval is the interval of reporting the numbers of the sampled and the dropped entries
+	// as an info entry, zero means no report.
+	ReportInterval time.Duration `yaml:"report-interval" json:"report-interval"`
+	// Hook is called with the decision of each entry which is subject to sampling.
+	Hook func(zapcore.Entry, zapcore.SamplingDecision) `yaml:"-" json:"-"`
+}
+
+// SamplingStats are the numbers of the sampled and the dropped entries of the levels since the sampler was created,
+// the levels which are not sampled are absent
+type SamplingStats struct {
+	Sampled map[zapcore.Level]uint64
+	Dropped map[zapcore.Level]uint64
+}
+
+// counter counts the entries with the same level and message in a tick
+type counter struct {
+	resetAt int64
+	counter uint64
+}
+
+// incCheckReset increases the counter, the counter is reset if the tick of t is a new one
+func (c *counter) incCheckReset(t time.Time, tick time.Duration) uint64 {
+	tn := t.UnixNano()
+	resetAfter := atomic.LoadInt64(&c.resetAt)
+	if resetAfter > tn {
+		return atomic.AddUint64(&c.counter, 1)
+	}
+
+	atomic.StoreUint64(&c.counter, 1)
+	newResetAfter := tn + tick.Nanoseconds()
+	if !atomic.CompareAndSwapInt64(&c.resetAt, resetAfter, newResetAfter) {
+		// we raced with another goroutine trying to reset, and it also reset
+		// the counter to 1, so we need to reincrement the counter.
+		return atomic.AddUint64(&c.counter, 1)
+	}
+
+	return 1
+}
+
+// counters are the counters of the levels, the messages are hashed into the counters of their levels
+type counters [numSamplingLevels][countersPerLevel]counter
+
+// get returns the counter of the level and the key
+func (cs *counters) get(level zapcore.Level, key string) *counter {
+	i := level - minSamplingLevel
+	j := fnv32a(key) % countersPerLevel
+	return &cs[i][j]
+}
+
+// fnv32a returns the FNV-1a hash of s
+func fnv32a(s string) uint32 {
+	hash := uint32(fnvOffset32)
+	for i := 0; i < len(s); i++ {
+		hash ^= uint32(s[i])
+		hash *= fnvPrime32
+	}
+
+	return hash
+}
+
+// samplingRule is the parsed sampling rule of a level
+type samplingRule struct {
+	enabled           bool
+	first, thereafter uint64
+}
+
+// parseSamplingRules returns the rules of the levels
+func parseSamplingRules(rules []SamplingRule) ([numSamplingLevels]samplingRule, error) {
+	var parsed [numSamplingLevels]samplingRule
+	for i, rule := range rules {
+		minLevel, maxLevel := minSamplingLevel, maxSamplingLevel
+		if rule.MinLevel != "" {
+			err := minLevel.UnmarshalText([]byte(rule.MinLevel))
+			if err != nil {
+				return parsed, errors.New(fmt.Sprintf(ErrInvalidSamplingRule, i, err.Error()))
+			}
+		}
+		if rule.MaxLevel != "" {
+			err := maxLevel.UnmarshalText([]byte(rule.MaxLevel))
+			if err != nil {
+				return parsed, errors.New(fmt.Sprintf(ErrInvalidSamplingRule, i, err.Error()))
+			}
+		}
+		if minLevel > maxLevel {
+			return parsed, errors.New(fmt.Sprintf(ErrInvalidSamplingRule, i, "min level is larger than max level"))
+		}
+		if rule.Initial < 0 || rule.Thereafter < 0 {
+			return parsed, errors.New(fmt.Sprintf(ErrInvalidSamplingRule, i, "initial and thereafter must not be negative"))
+		}
+		for level := minLevel; level <= maxLevel; level++ {
+			parsed[level-minSamplingLevel] = samplingRule{
+				enabled:    !rule.Disable,
+				first:      uint64(rule.Initial),
+				thereafter: uint64(rule.Thereafter),
+			}
+		}
+	}
+
+	return parsed, nil
+}
+
+// samplerState is the state of the sampler, it is shared by the clones made by With()
+type samplerState struct {
+	counts *counters
+	tick   time.Duration
+	rules  [numSamplingLevels]samplingRule
+	key    string
+	hook   func(zapcore.Entry, zapcore.SamplingDecision)
+	// stats are the numbers of the dropped and the sampled entries of the levels
+	stats [numSamplingLevels][2]uint64
+
+	// reported are the stats of the last report, they are only used by the report goroutine
+	reported [numSamplingLevels][2]uint64
+	// stop stops the report goroutine, and done is closed when it exits, they are nil without the report goroutine
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// sample returns true if the entry should be logged, value is the value of the key field of the entry
+func (s *samplerState) sample(ent zapcore.Entry, value string) bool {
+	rule := s.rules[ent.Level-minSamplingLevel]
+	key := ent.Message
+	if s.key != "" {
+		key += "\x00" + value
+	}
+	n := s.counts.get(ent.Level, key).incCheckReset(ent.Time, s.tick)
+	if n > rule.first && (rule.thereafter == 0 || (n-rule.first)%rule.thereafter != 0) {
+		s.record(ent, zapcore.LogDropped)
+		return false
+	}
+	s.record(ent, zapcore.LogSampled)
+
+	return true
+}
+
+// record counts the decision of the entry and calls the hook
+func (s *samplerState) record(ent zapcore.Entry, decision zapcore.SamplingDecision) {
+	i := samplingSampled
+	if decision == zapcore.LogDropped {
+		i = samplingDropped
+	}
+	atomic.AddUint64(&s.stats[ent.Level-minSamplingLevel][i], 1)
+	if s.hook != nil {
+		s.hook(ent, decision)
+	}
+}
+
+// samplingStats returns the stats of the levels which are sampled
+func (s *samplerState) samplingStats() SamplingStats {
+	stats := SamplingStats{Sampled: make(map[zapcore.Level]uint64), Dropped: make(map[zapcore.Level]uint64)}
+	for i, rule := range s.rules {
+		if !rule.enabled {
+			continue
+		}
+		level := minSamplingLevel + zapcore.Level(i)
+		stats.Sampled[level] = atomic.LoadUint64(&s.stats[i][samplingSampled])
+		stats.Dropped[level] = atomic.LoadUint64(&s.stats[i][samplingDropped])
+	}
+
+	return stats
+}
+
+// reportFields returns the fields of the numbers of the entries since the last report,
+// nil is returned if no entry is sampled or dropped
+func (s *samplerState) reportFields() []zapcore.Field {
+	var fields []zapcore.Field
+	for i := range s.stats {
+		sampled := atomic.LoadUint64(&s.stats[i][samplingSampled])
+		dropped := atomic.LoadUint64(&s.stats[i][samplingDropped])
+		if sampled == s.reported[i][samplingSampled] && dropped == s.reported[i][samplingDropped] {
+			continue
+		}
+		level := (minSamplingLevel + zapcore.Level(i)).String()
+		fields = append(fields,
+			zap.Uint64(level+SamplingSampledSuffix, sampled-s.reported[i][samplingSampled]),
+			zap.Uint64(level+SamplingDroppedSuffix, dropped-s.reported[i][samplingDropped]),
+		)
+		s.reported[i][samplingSampled] = sampled
+		s.reported[i][samplingDropped] = dropped
+	}
+
+	return fields
+}
+
+// sampler is a copy of zapcore.sampler with the rules of the levels and the key field, it implements CoreUnwrapper,
+// so the runtime setters, AddWriteSyncer() and Rotate() work through it
+type sampler struct {
+	zapcore.Core
+	*samplerState
+	// value is the value of the key field added by With(), it is valid if hasValue is true
+	value    string
+	hasValue bool
+}
+
+// NewSampler returns a core which logs the first entries with the same level and message in each tick,
+// and every thereafter-th entry after that, the other entries are dropped,
+// hook is called with the decision of each entry, it could be nil
+func NewSampler(core zapcore.Core, tick time.Duration, first, thereafter int,
+	hook func(zapcore.Entry, zapcore.SamplingDecision)) zapcore.Core {
+	state := &samplerState{
+		counts: &counters{},
+		tick:   tick,
+		hook:   hook,
+	}
+	for i := range state.rules {
+		state.rules[i] = samplingRule{enabled: true, first: uint64(first), thereafter: uint64(thereafter)}
+	}
+
+	return &sampler{Core: core, samplerState: state}
+}
+
+// NewSamplerWithConfig returns a core which samples the entries with the rules of the levels in the config,
+// the numbers of the sampled and the dropped entries are reported periodically if ReportInterval is set
+func NewSamplerWithConfig(core zapcore.Core, cfg *SamplerConfig) (zapcore.Core, error) {
+	rules, err := parseSamplingRules(cfg.Rules)
+	if err != nil {
+		return nil, err
+	}
+	tick := cfg.Tick
+	if tick <= 0 {
+		tick = defaultSamplingTick
+	}
+
+	s := &sampler{
+		Core: core,
+		samplerState: &samplerState{
+			counts: &counters{},
+			tick:   tick,
+			rules:  rules,
+			key:    cfg.Key,
+			hook:   cfg.Hook,
+		},
+	}
+	if cfg.ReportInterval > 0 {
+		ticker := time.NewTicker(cfg.ReportInterval)
+		s.startReport(ticker.C, ticker.Stop)
+	}
+
+	return s, nil
+}
+
+// startReport starts the goroutine which reports on each tick until the sampler is closed,
+// stopTicks is called when the goroutine exits
+func (s *sampler) startReport(ticks <-chan time.Time, stopTicks func()) {
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go func() {
+		defer close(s.done)
+		defer stopTicks()
+
+		for {
+			select {
+			case now := <-ticks:
+				s.report(now)
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// report logs the numbers of the sampled and the dropped entries since the last report,
+// the report entries are not sampled
+func (s *sampler) report(now time.Time) {
+	fields := s.reportFields()
+	if len(fields) == 0 {
+		return
+	}
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: now, Message: SamplingReportMessage}
+	if ce := s.Core.Check(ent, nil); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// Close stops the report goroutine and waits for it to exit, the clones made by With() are closed together,
+// the entries are still sampled after the sampler is closed, it is safe to call it more than once
+func (s *sampler) Close() error {
+	s.closeOnce.Do(func() {
+		if s.stop == nil {
+			return
+		}
+		close(s.stop)
+		<-s.done
+	})
+
+	return nil
+}
+
+// Unwrap returns the core which is sampled
+func (s *sampler) Unwrap() zapcore.Core {
+	return s.Core
+}
+
+// Level returns the minimum enabled level of the sampled core
+func (s *sampler) Level() zapcore.Level {
+	return zapcore.LevelOf(s.Core)
+}
+
+func (s *sampler) With(fields []zapcore.Field) zapcore.Core {
+	clone := &sampler{
+		Core:         s.Core.With(fields),
+		samplerState: s.samplerState,
+		value:        s.value,
+		hasValue:     s.hasValue,
+	}
+	if value, ok := s.keyValue(fields); ok {
+		clone.value, clone.hasValue = value, true
+	}
+
+	return clone
+}
+
+func (s *sampler) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !s.Enabled(ent.Level) {
+		return ce
+	}
+
+	if ent.Level >= minSamplingLevel && ent.Level <= maxSamplingLevel && s.rules[ent.Level-minSamplingLevel].enabled {
+		if s.key != "" && !s.hasValue {
+			// the key field may be one of the fields of the entry, which are only passed to Write(),
+			// so the entry is sampled by Write()
+			return ce.AddCore(ent, s)
+		}
+		if !s.sample(ent, s.value) {
+			return ce
+		}
+	}
+
+	return s.Core.Check(ent, ce)
+}
+
+// Write samples the entry by the value of the key field, and writes it to the sampled core if it is not dropped,
+// it is only called if the key field is not added by With(), see Check()
+func (s *sampler) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	value, _ := s.keyValue(fields)
+	if !s.sample(ent, value) {
+		return nil
+	}
+	ce := s.Core.Check(ent, nil)
+	if ce == nil {
+		return nil
+	}
+	// the write errors of the sampled core are reported to the recorder, so they could be returned
+	recorder := &errorRecorder{}
+	ce.ErrorOutput = recorder
+	ce.Write(fields...)
+
+	return recorder.err()
+}
+
+// keyValue returns the value of the key field in the fields
+func (s *sampler) keyValue(fields []zapcore.Field) (string, bool) {
+	if s.key == "" {
+		return "", false
+	}
+	for i := len(fields) - 1; i >= 0; i-- {
+		f := fields[i]
+		if f.Key != s.key {
+			continue
+		}
+		return fieldValue(f), true
+	}
+
+	return "", false
+}
+
+// fieldValue returns the value of the field as a string, the common types are formatted without reflection
+func fieldValue(f zapcore.Field) string {
+	switch f.Type {
+	case zapcore.StringType:
+		return f.String
+	case zapcore.Int64Type, zapcore.Int32Type, zapcore.Int16Type, zapcore.Int8Type:
+		return strconv.FormatInt(f.Integer, 10)
+	case zapcore.Uint64Type, zapcore.Uint32Type, zapcore.Uint16Type, zapcore.Uint8Type, zapcore.UintptrType:
+		return strconv.FormatUint(uint64(f.Integer), 10)
+	case zapcore.BoolType:
+		return strconv.FormatBool(f.Integer == 1)
+	case zapcore.DurationType:
+		return time.Duration(f.Integer).String()
+	case zapcore.TimeType:
+		t := time.Unix(0, f.Integer)
+		if loc, ok := f.Interface.(*time.Location); ok {
+			t = t.In(loc)
+		}
+		return t.Format(time.RFC3339Nano)
+	case zapcore.TimeFullType:
+		return f.Interface.(time.Time).Format(time.RFC3339Nano)
+	case zapcore.ByteStringType:
+		return string(f.Interface.([]byte))
+	case zapcore.StringerType:
+		return f.Interface.(fmt.Stringer).String()
+	default:
+		if f.Interface != nil {
+			return fmt.Sprint(f.Interface)
+		}
+		return strconv.FormatInt(f.Integer, 10)
+	}
+}
+
+// errorRecorder is the error output of the checked entries written by the sampler, it records the errors
+type errorRecorder struct {
+	msgs []string
+}
+
+// Write records the error message
+func (r *errorRecorder) Write(p []byte) (int, error) {
+	r.msgs = append(r.msgs, strings.TrimSpace(string(p)))
+	return len(p), nil
+}
+
+// Sync does nothing
+func (r *errorRecorder) Sync() error {
+	return nil
+}
+
+// err returns the recorded errors, nil is returned if there is no error
+func (r *errorRecorder) err() error {
+	if len(r.msgs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(r.msgs, "; "))
+}
+
+// getSampler returns the *sampler of given core, the decorating cores are unwrapped
+func getSampler(core zapcore.Core) (*sampler, error) {
+	for {
+		switch c := core.(type) {
+		case *sampler:
+			return c, nil
+		case CoreUnwrapper:
+			core = c.Unwrap()
+		default:
+			return nil, errors.New(ErrNotSampled)
+		}
+	}
+}
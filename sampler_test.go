@@ -0,0 +1,158 @@
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newSamplerTestLogger returns a text logger with the sampler which writes to b without the time, the caller and the stack
+func newSamplerTestLogger(t *testing.T, b *lockedBuilder, sc *SamplerConfig) *Logger {
+	cfg := newOutputTestConfig(DefaultLogLevel, LogFormatText)
+	cfg.DisableStacktrace = true
+	cfg.Sampler = sc
+	zapLogger, _, err := InitZapLoggerWithWriteSyncer(cfg, zapcore.AddSync(b))
+	assert.Nil(t, err, "init logger failed")
+
+	return NewMyLogger(zapLogger)
+}
+
+func TestSampler(t *testing.T) {
+	asst := assert.New(t)
+
+	cases := []struct {
+		name     string
+		config   SamplerConfig
+		write    func(logger *Logger)
+		expected string
+		sampled  uint64
+		dropped  uint64
+	}{
+		{
+			"levels without rule and disabled levels are not sampled",
+			SamplerConfig{Rules: []SamplingRule{{MaxLevel: "warn", Initial: 2}, {MinLevel: "warn", MaxLevel: "warn", Disable: true}}},
+			func(logger *Logger) {
+				for i := 0; i < 3; i++ {
+					logger.Info("info")
+					logger.Warn("warn")
+					logger.Error("error")
+				}
+			},
+			"[INFO][info]\n[WARN][warn]\n[ERROR][error]\n[INFO][info]\n[WARN][warn]\n[ERROR][error]\n[WARN][warn]\n[ERROR][error]\n",
+			2, 1,
+		},
+		{
+			"every thereafter-th entry is sampled",
+			SamplerConfig{Rules: []SamplingRule{{Initial: 1, Thereafter: 2}}},
+			func(logger *Logger) {
+				for i := 0; i < 5; i++ {
+					logger.Info("info", zap.Int("n", i))
+				}
+			},
+			"[INFO][info][n=0]\n[INFO][info][n=2]\n[INFO][info][n=4]\n",
+			3, 2,
+		},
+		{
+			"entries are sampled by the key field",
+			SamplerConfig{Rules: []SamplingRule{{Initial: 1}}, Key: "user_id"},
+			func(logger *Logger) {
+				child := NewMyLogger(logger.zapLogger.With(zap.String("user_id", "c")))
+				for i := 0; i < 2; i++ {
+					logger.Info("login", zap.String("user_id", "a"))
+					logger.Info("login", zap.String("user_id", "b"))
+					child.Info("login")
+				}
+			},
+			"[INFO][login][user_id=a]\n[INFO][login][user_id=b]\n[INFO][login] [user_id=c]\n",
+			3, 3,
+		},
+	}
+	for _, c := range cases {
+		var text lockedBuilder
+		var dropped uint64
+		c.config.Tick = time.Hour
+		c.config.Hook = func(ent zapcore.Entry, decision zapcore.SamplingDecision) {
+			if decision == zapcore.LogDropped {
+				dropped++
+			}
+		}
+		logger := newSamplerTestLogger(t, &text, &c.config)
+		c.write(logger)
+		asst.Equal(c.expected, text.String(), c.name)
+
+		stats, err := logger.GetSamplingStats()
+		asst.Nil(err, c.name)
+		asst.Equal(c.sampled, stats.Sampled[zapcore.InfoLevel], c.name)
+		asst.Equal(c.dropped, stats.Dropped[zapcore.InfoLevel], c.name)
+		asst.Equal(c.dropped, dropped, "the hook should be called with the dropped entries: "+c.name)
+		asst.Nil(logger.SetSeperator(DefaultLogSeparator), "the setters should work through the sampler: "+c.name)
+	}
+
+	cfg := NewConfigWithStdout(DefaultLogLevel, LogFormatText)
+	cfg.Sampler = &SamplerConfig{Rules: []SamplingRule{{MinLevel: "error", MaxLevel: "info"}}}
+	_, _, err := InitZapLoggerWithWriteSyncer(cfg, NewStdoutWriteSyncer())
+	asst.NotNil(err, "invalid sampling rule should fail")
+	_, err = NewMyLogger(zap.NewNop()).GetSamplingStats()
+	asst.NotNil(err, "the logger should be sampled")
+}
+
+func TestSamplerReport(t *testing.T) {
+	asst := assert.New(t)
+
+	var text lockedBuilder
+	logger := newSamplerTestLogger(t, &text, &SamplerConfig{Rules: []SamplingRule{{Initial: 1}}})
+	s, err := getSampler(logger.zapLogger.Core())
+	asst.Nil(err, "get sampler failed")
+	ticks := make(chan time.Time)
+	stopped := false
+	s.startReport(ticks, func() { stopped = true })
+
+	for i := 0; i < 3; i++ {
+		logger.Info("login")
+	}
+	logger.Warn("retry")
+	// the sends return after the former ticks are reported, nothing is reported without new entries
+	ticks <- time.Now()
+	ticks <- time.Now()
+	ticks <- time.Now()
+	asst.Nil(s.Close(), "close sampler failed")
+	asst.Equal("[INFO][login]\n[WARN][retry]\n[INFO][\"sampling report\"][info-sampled=1][info-dropped=2][warn-sampled=1][warn-dropped=0]\n", text.String())
+	asst.True(stopped, "the ticks should be stopped")
+}
+
+func TestSamplerClose(t *testing.T) {
+	asst := assert.New(t)
+
+	logger := newSamplerTestLogger(t, &lockedBuilder{}, &SamplerConfig{ReportInterval: time.Hour})
+	child := NewMyLogger(logger.zapLogger.With(zap.String("service", "log")))
+	s, err := getSampler(logger.zapLogger.Core())
+	asst.Nil(err, "get sampler failed")
+
+	// the report goroutine exits when the logger or any of its clones is closed
+	asst.Nil(child.Close(), "close logger failed")
+	select {
+	case <-s.done:
+	default:
+		asst.Fail("the report goroutine should exit")
+	}
+	asst.Nil(logger.Close(), "close logger twice should not fail")
+	asst.Nil(NewMyLogger(zap.NewNop()).Close(), "close logger without sampler should not fail")
+}
+
+func TestSamplerKeyValue(t *testing.T) {
+	asst := assert.New(t)
+
+	ts := time.Date(2021, 1, 2, 3, 4, 5, 6, time.UTC)
+	asst.Equal("a", fieldValue(zap.String("k", "a")))
+	asst.Equal("-1", fieldValue(zap.Int("k", -1)))
+	asst.Equal("18446744073709551615", fieldValue(zap.Uint64("k", 18446744073709551615)))
+	asst.Equal("true", fieldValue(zap.Bool("k", true)))
+	asst.Equal("1.5s", fieldValue(zap.Duration("k", 1500*time.Millisecond)))
+	asst.Equal("2021-01-02T03:04:05.000000006Z", fieldValue(zap.Time("k", ts)))
+	asst.Equal("2021-01-02T12:04:05.000000006+09:00", fieldValue(zap.Time("k", ts.In(time.FixedZone("JST", 9*3600)))))
+	asst.Equal("0001-01-01T00:00:00Z", fieldValue(zap.Time("k", time.Time{})))
+	asst.Equal("[a b]", fieldValue(zap.Any("k", []string{"a", "b"})))
+}
@@ -0,0 +1,94 @@
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// snapshot holds an immutable value which is swapped atomically, the loads are lock free,
+// and the updates are serialized by the mutex, so the value is safe to be changed
+// while the other goroutines are using the loaded one, it is embedded by the shared settings of the encoders
+// and the shared lists of the cores
+type snapshot struct {
+	mu    sync.Mutex
+	value atomic.Value
+}
+
+// store stores the initial value
+func (s *snapshot) store(value interface{}) {
+	s.value.Store(value)
+}
+
+// load returns the latest value, it must not be changed
+func (s *snapshot) load() interface{} {
+	return s.value.Load()
+}
+
+// update swaps in the value returned by update, which is called with the latest value and must not change it
+func (s *snapshot) update(update func(value interface{}) interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.value.Store(update(s.value.Load()))
+}
+
+// sharedTextSettings holds the latest settings of the text encoder, it is shared by the clones of the encoder,
+// so the runtime setters reach all the clones made by With()
+type sharedTextSettings struct {
+	snapshot
+}
+
+// newSharedTextSettings returns a *sharedTextSettings with the initial settings
+func newSharedTextSettings(settings *textSettings) *sharedTextSettings {
+	s := &sharedTextSettings{}
+	s.store(settings)
+
+	return s
+}
+
+// load returns the latest settings, they must not be changed
+func (s *sharedTextSettings) load() *textSettings {
+	return s.snapshot.load().(*textSettings)
+}
+
+// update copies the latest settings, changes the copy and swaps it in
+func (s *sharedTextSettings) update(update func(settings *textSettings)) {
+	s.snapshot.update(func(value interface{}) interface{} {
+		settings := *value.(*textSettings)
+		update(&settings)
+		return &settings
+	})
+}
+
+// jsonSettings are the settings of the json encoder which could be changed at runtime
+type jsonSettings struct {
+	TimeFormat    string
+	callerEncoder string
+}
+
+// sharedJSONSettings holds the latest runtime settings of the json encoder like sharedTextSettings
+type sharedJSONSettings struct {
+	snapshot
+}
+
+// newSharedJSONSettings returns a *sharedJSONSettings with the initial settings
+func newSharedJSONSettings(settings jsonSettings) *sharedJSONSettings {
+	s := &sharedJSONSettings{}
+	s.store(settings)
+
+	return s
+}
+
+// load returns the latest settings
+func (s *sharedJSONSettings) load() jsonSettings {
+	return s.snapshot.load().(jsonSettings)
+}
+
+// update copies the latest settings, changes the copy and swaps it in
+func (s *sharedJSONSettings) update(update func(settings *jsonSettings)) {
+	s.snapshot.update(func(value interface{}) interface{} {
+		settings := value.(jsonSettings)
+		update(&settings)
+		return settings
+	})
+}
@@ -0,0 +1,172 @@
+package log
+
+import (
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	stackFunctionKey = "function"
+	stackFileKey     = "file"
+	stackLineKey     = "line"
+)
+
+// DefaultStackFilters drops the frames of the go runtime, zap and this package
+var DefaultStackFilters = []string{"runtime.", "go.uber.org/zap", "github.com/romberli/log."}
+
+// StackConfig serializes stack trace related config in yaml/json.
+type StackConfig struct {
+	// Structured parses the stack trace into frames, the frames are encoded as an array of objects in json format,
+	// and as indented lines after the log line in text and console format.
+	Structured bool `yaml:"structured" json:"structured"`
+	// Filters are the prefixes of the function names, the matching frames will be dropped,
+	// for example: DefaultStackFilters.
+	Filters []string `yaml:"filters" json:"filters"`
+	// MaxDepth is the maximum number of the frames after filtering, zero means no limit.
+	MaxDepth int `yaml:"max-depth" json:"max-depth"`
+}
+
+// stackFrame is a frame of the stack trace
+type stackFrame struct {
+	Function string
+	File     string
+	Line     int
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler
+func (f stackFrame) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddString(stackFunctionKey, f.Function)
+	enc.AddString(stackFileKey, f.File)
+	enc.AddInt(stackLineKey, f.Line)
+
+	return nil
+}
+
+// String returns the frame in function file:line format
+func (f stackFrame) String() string {
+	return f.Function + " " + f.File + ":" + strconv.Itoa(f.Line)
+}
+
+// stackFrames is the frames of the stack trace
+type stackFrames []stackFrame
+
+// MarshalLogArray implements zapcore.ArrayMarshaler
+func (frames stackFrames) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, f := range frames {
+		err := enc.AppendObject(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// String returns the frames in the same format as the stack trace taken by zap
+func (frames stackFrames) String() string {
+	lines := make([]string, 0, len(frames)*2)
+	for _, f := range frames {
+		lines = append(lines, f.Function, "\t"+f.File+":"+strconv.Itoa(f.Line))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// lines returns each frame in function file:line format
+func (frames stackFrames) lines() []string {
+	lines := make([]string, len(frames))
+	for i, f := range frames {
+		lines[i] = f.String()
+	}
+
+	return lines
+}
+
+// stackFormatter filters and formats the stack traces
+type stackFormatter struct {
+	structured bool
+	filters    []string
+	maxDepth   int
+}
+
+// newStackFormatter returns a *stackFormatter, it returns nil if the stack traces should be kept as they are
+func newStackFormatter(sc StackConfig) *stackFormatter {
+	if !sc.Structured && len(sc.Filters) == 0 && sc.MaxDepth <= 0 {
+		return nil
+	}
+
+	return &stackFormatter{
+		structured: sc.Structured,
+		filters:    sc.Filters,
+		maxDepth:   sc.MaxDepth,
+	}
+}
+
+// frames parses the stack trace and returns the frames which are not filtered,
+// ok is false if the stack trace could not be parsed
+func (sf *stackFormatter) frames(stack string) (frames stackFrames, ok bool) {
+	frames, ok = parseStack(stack)
+	if !ok {
+		return nil, false
+	}
+
+	return sf.filter(frames), true
+}
+
+// filter returns the frames which are not filtered, and caps the number of the frames
+func (sf *stackFormatter) filter(frames stackFrames) stackFrames {
+	filtered := frames[:0]
+	for _, f := range frames {
+		if sf.filtered(f.Function) {
+			continue
+		}
+		filtered = append(filtered, f)
+		if sf.maxDepth > 0 && len(filtered) >= sf.maxDepth {
+			break
+		}
+	}
+
+	return filtered
+}
+
+// filtered returns true if the function matches any of the filters
+func (sf *stackFormatter) filtered(function string) bool {
+	for _, filter := range sf.filters {
+		if strings.HasPrefix(function, filter) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseStack parses the stack trace taken by zap, each frame has two lines,
+// the first line is the function, the second line is the tab indented file:line
+func parseStack(stack string) (stackFrames, bool) {
+	lines := strings.Split(strings.TrimRight(stack, "\n"), "\n")
+	if len(lines)%2 != 0 {
+		return nil, false
+	}
+
+	frames := make(stackFrames, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		location := strings.TrimSpace(lines[i+1])
+		idx := strings.LastIndexByte(location, ':')
+		if !strings.HasPrefix(lines[i+1], "\t") || idx < 0 {
+			return nil, false
+		}
+		line, err := strconv.Atoi(location[idx+1:])
+		if err != nil {
+			return nil, false
+		}
+		frames = append(frames, stackFrame{
+			Function: lines[i],
+			File:     location[:idx],
+			Line:     line,
+		})
+	}
+
+	return frames, true
+}
@@ -0,0 +1,356 @@
+package log
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pingcap/errors"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// SyslogProtocolRFC5424 is the syslog protocol with structured data, it is the default syslog protocol
+	SyslogProtocolRFC5424 = "rfc5424"
+	// SyslogProtocolRFC3164 is the legacy BSD syslog protocol,
+	// the structured data is appended to the message as it has no structured data part
+	SyslogProtocolRFC3164 = "rfc3164"
+
+	// DefaultSyslogFacility is the facility of the logs if it is not specified
+	DefaultSyslogFacility = "user"
+	// DefaultSyslogSDID is the SD-ID of the structured data element which contains the fields,
+	// 32473 is the private enterprise number reserved for documentation, see RFC 5612
+	DefaultSyslogSDID = "fields@32473"
+
+	syslogVersion    = "1"
+	syslogNilValue   = "-"
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	// the max lengths of the header fields and the param names, see RFC 5424 section 6
+	syslogMaxHostnameLength   = 255
+	syslogMaxAppNameLength    = 48
+	syslogMaxMsgIDLength      = 32
+	syslogMaxNameLength       = 32
+	syslogMaxRFC3164TagLength = 32
+)
+
+var (
+	ErrInvalidSyslogProtocol = "invalid syslog protocol %s, must be either rfc5424 or rfc3164."
+	ErrInvalidSyslogFacility = "invalid syslog facility %s, must be one of kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp or local0 to local7."
+	ErrInvalidSyslogSDID     = "invalid syslog sd-id %s, must be 1 to 32 printable us-ascii characters except =, space, ] and \"."
+
+	_syslogFacilities = map[string]int{
+		"kern":     0,
+		"user":     1,
+		"mail":     2,
+		"daemon":   3,
+		"auth":     4,
+		"syslog":   5,
+		"lpr":      6,
+		"news":     7,
+		"uucp":     8,
+		"cron":     9,
+		"authpriv": 10,
+		"ftp":      11,
+		"local0":   16,
+		"local1":   17,
+		"local2":   18,
+		"local3":   19,
+		"local4":   20,
+		"local5":   21,
+		"local6":   22,
+		"local7":   23,
+	}
+)
+
+// SyslogConfig serializes syslog format related config in yaml/json.
+type SyslogConfig struct {
+	// Protocol is one of rfc5424 or rfc3164, default is rfc5424.
+	Protocol string `yaml:"protocol" json:"protocol"`
+	// Facility is one of kern, user, mail, daemon, auth, syslog, lpr, news, uucp, cron, authpriv, ftp
+	// or local0 to local7, default is user.
+	Facility string `yaml:"facility" json:"facility"`
+	// Hostname is the HOSTNAME of the header, default is the host name reported by the kernel.
+	Hostname string `yaml:"hostname" json:"hostname"`
+	// AppName is the APP-NAME of the header, default is the base name of the executable.
+	AppName string `yaml:"app-name" json:"app-name"`
+	// MsgID is the MSGID of the header, default is the logger name.
+	MsgID string `yaml:"msg-id" json:"msg-id"`
+	// SDID is the SD-ID of the structured data element which contains the fields, default is DefaultSyslogSDID.
+	SDID string `yaml:"sd-id" json:"sd-id"`
+}
+
+// validate validates the syslog config
+func (sc SyslogConfig) validate() error {
+	protocol := strings.ToLower(sc.Protocol)
+	if protocol != "" && protocol != SyslogProtocolRFC5424 && protocol != SyslogProtocolRFC3164 {
+		return errors.New(fmt.Sprintf(ErrInvalidSyslogProtocol, sc.Protocol))
+	}
+	if sc.Facility != "" {
+		_, ok := _syslogFacilities[strings.ToLower(sc.Facility)]
+		if !ok {
+			return errors.New(fmt.Sprintf(ErrInvalidSyslogFacility, sc.Facility))
+		}
+	}
+	if sc.SDID != "" && (len(sc.SDID) > syslogMaxNameLength || sanitizeSyslogName(sc.SDID, syslogMaxNameLength) != sc.SDID) {
+		return errors.New(fmt.Sprintf(ErrInvalidSyslogSDID, sc.SDID))
+	}
+
+	return nil
+}
+
+// syslogSeverity returns the syslog severity of the level
+func syslogSeverity(level zapcore.Level) int {
+	switch level {
+	case zapcore.DebugLevel:
+		return 7
+	case zapcore.InfoLevel:
+		return 6
+	case zapcore.WarnLevel:
+		return 4
+	case zapcore.ErrorLevel:
+		return 3
+	case zapcore.DPanicLevel:
+		return 2
+	case zapcore.PanicLevel:
+		return 1
+	case zapcore.FatalLevel:
+		return 0
+	default:
+		return 5
+	}
+}
+
+// syslogHeader is the static part of the syslog header, it is shared by the clones of the encoder
+type syslogHeader struct {
+	rfc3164  bool
+	facility int
+	hostname string
+	appName  string
+	procID   string
+	msgID    string
+	sdID     string
+}
+
+// newSyslogHeader returns a *syslogHeader, the config should be validated before
+func newSyslogHeader(sc SyslogConfig) *syslogHeader {
+	facility, ok := _syslogFacilities[strings.ToLower(sc.Facility)]
+	if !ok {
+		facility = _syslogFacilities[DefaultSyslogFacility]
+	}
+	sdID := sc.SDID
+	if sdID == "" {
+		sdID = DefaultSyslogSDID
+	}
+
+	return &syslogHeader{
+		rfc3164:  strings.ToLower(sc.Protocol) == SyslogProtocolRFC3164,
+		facility: facility,
+		hostname: sanitizeSyslogHeaderField(getHostname(sc.Hostname), syslogMaxHostnameLength),
+		appName:  sanitizeSyslogHeaderField(getAppName(sc.AppName), syslogMaxAppNameLength),
+		procID:   strconv.Itoa(os.Getpid()),
+		msgID:    sc.MsgID,
+		sdID:     sdID,
+	}
+}
+
+// syslogEncoder encodes the entries in syslog format, the fields are encoded as the params
+// of a structured data element, the namespaces and the nested objects are flattened into dotted names,
+// and the arrays and the other values are encoded as they are in text format
+type syslogEncoder struct {
+	*flatEncoder
+	header *syslogHeader
+}
+
+// NewSyslogEncoder creates a syslog encoder, the protocol, facility and header fields are specified by Config.Syslog
+func NewSyslogEncoder(cfg *Config) zapcore.Encoder {
+	return newSyslogEncoder(cfg)
+}
+
+// newSyslogEncoder returns a *syslogEncoder
+func newSyslogEncoder(cfg *Config) *syslogEncoder {
+	header := newSyslogHeader(cfg.Syslog)
+
+	return &syslogEncoder{
+		flatEncoder: newFlatEncoder(cfg, LogFormatSyslog, header),
+		header:      header,
+	}
+}
+
+func (enc *syslogEncoder) Clone() zapcore.Encoder {
+	return &syslogEncoder{
+		flatEncoder: enc.flatEncoder.clone(),
+		header:      enc.header,
+	}
+}
+
+func (enc *syslogEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	// the params are encoded first, so that the nil value could be added if there is no param
+	params := enc.cloned()
+	if ent.Caller.Defined && params.CallerKey != "" {
+		params.writeParam(params.CallerKey, params.caller(ent.Caller), false)
+	}
+	if ent.Caller.Defined && params.FunctionKey != "" {
+		params.writeParam(params.FunctionKey, ent.Caller.Function, false)
+	}
+	_, _ = params.buf.Write(enc.buf.Bytes())
+	params.addFields(fields)
+	if stack := params.stack(ent); stack != "" {
+		params.writeParam(params.StacktraceKey, stack, false)
+	}
+
+	final := enc.textEncoder.cloned()
+	final.buf.AppendByte('<')
+	final.buf.AppendInt(int64(enc.header.facility*8 + syslogSeverity(ent.Level)))
+	final.buf.AppendByte('>')
+	if enc.header.rfc3164 {
+		enc.addRFC3164Header(final, ent)
+		final.safeAddString(final.limiter.truncateMessage(ent.Message))
+		if params.buf.Len() > 0 {
+			final.buf.AppendByte(' ')
+			enc.appendStructuredData(final, params)
+		}
+	} else {
+		enc.addRFC5424Header(final, ent)
+		if params.buf.Len() > 0 {
+			enc.appendStructuredData(final, params)
+		} else {
+			final.buf.AppendString(syslogNilValue)
+		}
+		if ent.Message != "" {
+			final.buf.AppendByte(' ')
+			final.safeAddString(final.limiter.truncateMessage(ent.Message))
+		}
+	}
+	params.free()
+	final.addLineEnding()
+	final.truncateEntry()
+
+	ret := final.buf
+	putTextEncoder(final)
+	return ret, nil
+}
+
+// addRFC5424Header adds VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID and a space
+func (enc *syslogEncoder) addRFC5424Header(final *textEncoder, ent zapcore.Entry) {
+	final.buf.AppendString(syslogVersion)
+	final.buf.AppendByte(' ')
+	if final.TimeKey != "" {
+		t := ent.Time
+		if final.timeLocation != nil {
+			t = t.In(final.timeLocation)
+		}
+		final.buf.AppendTime(t, syslogTimeFormat)
+	} else {
+		final.buf.AppendString(syslogNilValue)
+	}
+	msgID := enc.header.msgID
+	if msgID == "" {
+		msgID = ent.LoggerName
+	}
+	for _, field := range []string{
+		enc.header.hostname,
+		enc.header.appName,
+		enc.header.procID,
+		sanitizeSyslogHeaderField(msgID, syslogMaxMsgIDLength),
+	} {
+		final.buf.AppendByte(' ')
+		final.buf.AppendString(field)
+	}
+	final.buf.AppendByte(' ')
+}
+
+// addRFC3164Header adds TIMESTAMP HOSTNAME TAG[PID]: and a space, the timestamp is always added,
+// as the receivers could not parse the header without it
+func (enc *syslogEncoder) addRFC3164Header(final *textEncoder, ent zapcore.Entry) {
+	t := ent.Time
+	if final.timeLocation != nil {
+		t = t.In(final.timeLocation)
+	}
+	final.buf.AppendTime(t, time.Stamp)
+	final.buf.AppendByte(' ')
+	if enc.header.hostname != syslogNilValue {
+		final.buf.AppendString(enc.header.hostname)
+		final.buf.AppendByte(' ')
+	}
+	tag := enc.header.appName
+	if len(tag) > syslogMaxRFC3164TagLength {
+		tag = tag[:syslogMaxRFC3164TagLength]
+	}
+	final.buf.AppendString(tag)
+	final.buf.AppendByte('[')
+	final.buf.AppendString(enc.header.procID)
+	final.buf.AppendString("]: ")
+}
+
+// appendStructuredData appends the params as a structured data element
+func (enc *syslogEncoder) appendStructuredData(final *textEncoder, params *flatEncoder) {
+	final.buf.AppendByte('[')
+	final.buf.AppendString(enc.header.sdID)
+	_, _ = final.buf.Write(params.buf.Bytes())
+	final.buf.AppendByte(']')
+}
+
+// writeParam writes the param as a space and PARAM-NAME="PARAM-VALUE", the value is escaped
+// with the rules of the text encoder, and ] is escaped as well, as it is required by PARAM-VALUE
+func (h *syslogHeader) writeParam(enc *textEncoder, name, val string, number bool) {
+	enc.buf.AppendByte(' ')
+	enc.buf.AppendString(sanitizeSyslogName(name, syslogMaxNameLength))
+	enc.buf.AppendString(`="`)
+	for i := 0; i < len(val); {
+		if val[i] == ']' {
+			enc.buf.AppendString(`\]`)
+			i++
+			continue
+		}
+		if enc.tryAddRuneSelf(val[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(val[i:])
+		if enc.tryAddRuneError(r, size) {
+			i++
+			continue
+		}
+		enc.buf.AppendString(val[i : i+size])
+		i += size
+	}
+	enc.buf.AppendByte('"')
+}
+
+// sanitizeSyslogName replaces the characters which are not allowed in SD-NAME with underscores,
+// and cuts the name to the max length
+func sanitizeSyslogName(name string, maxLength int) string {
+	if len(name) > maxLength {
+		name = name[:maxLength]
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// sanitizeSyslogHeaderField replaces the characters which are not printable us-ascii with underscores,
+// and cuts the field to the max length, empty field returns the nil value
+func sanitizeSyslogHeaderField(field string, maxLength int) string {
+	if field == "" {
+		return syslogNilValue
+	}
+	if len(field) > maxLength {
+		field = field[:maxLength]
+	}
+
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, field)
+}
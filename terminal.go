@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package log
+
+import (
+	"os"
+)
+
+// isTerminal returns true if the file is a character device,
+// which is a good approximation of a terminal on the other platforms
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	return st.Mode()&os.ModeCharDevice != 0
+}
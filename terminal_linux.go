@@ -0,0 +1,14 @@
+package log
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal returns true if the file is a terminal
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+
+	return err == nil
+}
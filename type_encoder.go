@@ -0,0 +1,99 @@
+package log
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	// _typeEncoders holds a map[reflect.Type]TypeEncoder, the map is copied on write,
+	// so that it could be read without lock
+	_typeEncoders     atomic.Value
+	_typeEncodersLock sync.Mutex
+)
+
+// TypeEncoder serializes a value of the registered type, v is never a nil pointer
+type TypeEncoder func(v interface{}, enc zapcore.PrimitiveArrayEncoder)
+
+// RegisterTypeEncoder registers the encoder of the type, the registered encoders are preferred over
+// the json reflection of zap.Reflect() and zap.Any() fields, the encoder of type T is also used for *T,
+// registering a nil encoder removes the encoder of the type
+func RegisterTypeEncoder(t reflect.Type, encoder TypeEncoder) {
+	_typeEncodersLock.Lock()
+	defer _typeEncodersLock.Unlock()
+
+	old, _ := _typeEncoders.Load().(map[reflect.Type]TypeEncoder)
+	encoders := make(map[reflect.Type]TypeEncoder, len(old)+1)
+	for k, v := range old {
+		encoders[k] = v
+	}
+	if encoder == nil {
+		delete(encoders, t)
+	} else {
+		encoders[t] = encoder
+	}
+	_typeEncoders.Store(encoders)
+}
+
+// getTypeEncoder returns the encoder of the value, the registered encoders are looked up first,
+// then encoding.TextMarshaler and fmt.Stringer are used if they are enabled, it returns nil if no encoder is found,
+// and the value should be encoded by json reflection
+func getTypeEncoder(v interface{}, useTextMarshaler, useStringer bool) TypeEncoder {
+	if v == nil {
+		return nil
+	}
+	val := reflect.ValueOf(v)
+	if val.Kind() == reflect.Ptr && val.IsNil() {
+		// let json reflection encode it as null
+		return nil
+	}
+
+	encoders, _ := _typeEncoders.Load().(map[reflect.Type]TypeEncoder)
+	if len(encoders) > 0 {
+		encoder, ok := encoders[val.Type()]
+		if ok {
+			return encoder
+		}
+		if val.Kind() == reflect.Ptr {
+			encoder, ok = encoders[val.Type().Elem()]
+			if ok {
+				return func(v interface{}, enc zapcore.PrimitiveArrayEncoder) {
+					encoder(reflect.ValueOf(v).Elem().Interface(), enc)
+				}
+			}
+		}
+	}
+
+	if useTextMarshaler {
+		if _, ok := v.(encoding.TextMarshaler); ok {
+			return textMarshalerEncoder
+		}
+	}
+	if useStringer {
+		if _, ok := v.(fmt.Stringer); ok {
+			return stringerEncoder
+		}
+	}
+
+	return nil
+}
+
+// textMarshalerEncoder serializes an encoding.TextMarshaler, the error message is used if it fails
+func textMarshalerEncoder(v interface{}, enc zapcore.PrimitiveArrayEncoder) {
+	text, err := v.(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		enc.AppendString(err.Error())
+		return
+	}
+	enc.AppendByteString(text)
+}
+
+// stringerEncoder serializes a fmt.Stringer
+func stringerEncoder(v interface{}, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(v.(fmt.Stringer).String())
+}
@@ -0,0 +1,105 @@
+package log
+
+import (
+	"flag"
+	"strconv"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultVerbosity is the verbosity threshold if it is not specified, only V(0) is enabled
+	DefaultVerbosity = 0
+	// VerbosityFlagName is the name of the flag registered by InitVerbosityFlag(), it is the same as klog and glog
+	VerbosityFlagName = "v"
+)
+
+var (
+	// _verbosity is the verbosity threshold of V(), it is shared by all the loggers like the -v flag of klog
+	_verbosity int32 = DefaultVerbosity
+	// _verbosityFlagSet is 1 if the threshold is set by the -v flag, which takes precedence over Config.Verbosity
+	_verbosityFlagSet int32
+)
+
+// GetVerbosity returns the verbosity threshold
+func GetVerbosity() int {
+	return int(atomic.LoadInt32(&_verbosity))
+}
+
+// SetVerbosity sets the verbosity threshold, V(level) is enabled if level is not larger than the threshold
+func SetVerbosity(verbosity int) {
+	atomic.StoreInt32(&_verbosity, int32(verbosity))
+}
+
+// verbosityFlag implements flag.Value with the verbosity threshold
+type verbosityFlag struct{}
+
+// String implements flag.Value
+func (verbosityFlag) String() string {
+	return strconv.Itoa(GetVerbosity())
+}
+
+// Set implements flag.Value
+func (verbosityFlag) Set(value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	SetVerbosity(v)
+	atomic.StoreInt32(&_verbosityFlagSet, 1)
+
+	return nil
+}
+
+// setConfigVerbosity sets the verbosity threshold of Config.Verbosity, zero verbosity leaves the threshold unchanged,
+// and the threshold set by the -v flag is not overridden
+func setConfigVerbosity(verbosity int) {
+	if verbosity == 0 || atomic.LoadInt32(&_verbosityFlagSet) == 1 {
+		return
+	}
+	SetVerbosity(verbosity)
+}
+
+// InitVerbosityFlag registers the -v flag to the flag set, which sets the verbosity threshold,
+// nil flag set means flag.CommandLine
+func InitVerbosityFlag(fs *flag.FlagSet) {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	fs.Var(verbosityFlag{}, VerbosityFlagName, "number for the log level verbosity")
+}
+
+// Verbose logs the messages at InfoLevel if it is enabled, it is returned by V()
+type Verbose struct {
+	// logger is nil if the verbosity is not enabled
+	logger *Logger
+}
+
+// newVerbose returns a Verbose which is enabled if level is not larger than the verbosity threshold
+func newVerbose(logger *Logger, level int) Verbose {
+	if level > GetVerbosity() {
+		return Verbose{}
+	}
+
+	return Verbose{logger: logger}
+}
+
+// Enabled returns true if the verbosity is enabled
+func (v Verbose) Enabled() bool {
+	return v.logger != nil
+}
+
+// Info logs a message at InfoLevel if the verbosity is enabled.
+func (v Verbose) Info(msg string, fields ...zap.Field) {
+	if v.logger != nil {
+		v.logger.zapLogger.Info(msg, fields...)
+	}
+}
+
+// Infof uses fmt.Sprintf to log a templated message if the verbosity is enabled.
+func (v Verbose) Infof(template string, args ...interface{}) {
+	if v.logger != nil {
+		v.logger.SugaredLogger.Infof(template, args...)
+	}
+}
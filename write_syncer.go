@@ -109,3 +109,28 @@ func (ws MultiWriteSyncer) Sync() error {
 	}
 	return err.ErrorOrNil()
 }
+
+// isTerminalWriteSyncer returns true if all the underlying writers of the write syncer are terminals
+func isTerminalWriteSyncer(ws zapcore.WriteSyncer) bool {
+	syncerList := []zapcore.WriteSyncer{ws}
+	mws, ok := ws.(MultiWriteSyncer)
+	if ok {
+		syncerList = mws.List()
+	}
+	if len(syncerList) == 0 {
+		return false
+	}
+
+	for _, syncer := range syncerList {
+		s, ok := syncer.(*WriteSyncer)
+		if !ok {
+			return false
+		}
+		f, ok := s.GetWriter().(*os.File)
+		if !ok || !isTerminal(f) {
+			return false
+		}
+	}
+
+	return true
+}
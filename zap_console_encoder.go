@@ -0,0 +1,289 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// ColorAuto colorizes the console format only if all the outputs are terminals
+	ColorAuto = "auto"
+	// ColorAlways always colorizes the console format
+	ColorAlways = "always"
+	// ColorNever never colorizes the console format
+	ColorNever = "never"
+
+	// DefaultConsoleCallerWidth is the minimum width of the caller column of the console format
+	DefaultConsoleCallerWidth = 24
+
+	// consoleLevelWidth is the width of the longest capital level, which is DPANIC
+	consoleLevelWidth = 6
+	consoleIndent     = "    "
+
+	colorReset   = "\x1b[0m"
+	colorRed     = "\x1b[31m"
+	colorGreen   = "\x1b[32m"
+	colorYellow  = "\x1b[33m"
+	colorBlue    = "\x1b[34m"
+	colorMagenta = "\x1b[35m"
+)
+
+// levelColor returns the ANSI color of the level
+func levelColor(level zapcore.Level) string {
+	switch level {
+	case zapcore.DebugLevel:
+		return colorMagenta
+	case zapcore.InfoLevel:
+		return colorBlue
+	case zapcore.WarnLevel:
+		return colorYellow
+	case zapcore.ErrorLevel, zapcore.DPanicLevel, zapcore.PanicLevel, zapcore.FatalLevel:
+		return colorRed
+	default:
+		return colorGreen
+	}
+}
+
+// consoleEncoder is a textEncoder for interactive terminals,
+// it aligns the level, caller and message columns, colorizes the level
+// and prints stack traces and verbose errors as indented multi-line blocks
+type consoleEncoder struct {
+	*textEncoder
+	enableColor bool
+	// verboses are the verbose errors of the fields added by With()
+	verboses []string
+}
+
+// NewConsoleEncoder creates a console encoder, if Config.Color is auto,
+// the level will be colorized only if stdout is a terminal
+func NewConsoleEncoder(cfg *Config) zapcore.Encoder {
+	return newConsoleEncoder(cfg, cfg.enableColor(NewStdoutWriteSyncer()))
+}
+
+// newConsoleEncoder returns a *consoleEncoder
+func newConsoleEncoder(cfg *Config, enableColor bool) *consoleEncoder {
+	te := newTextEncoder(cfg, nil)
+	if enableColor {
+		// the level is colorized by the console encoder, so the color level encoders are replaced by the plain ones
+		te.EncodeLevel = newPlainLevelEncoder(cfg.Encoder.LevelEncoder)
+	}
+
+	return &consoleEncoder{
+		textEncoder: te,
+		enableColor: enableColor,
+	}
+}
+
+func (enc *consoleEncoder) Clone() zapcore.Encoder {
+	return &consoleEncoder{
+		textEncoder: enc.textEncoder.Clone().(*textEncoder),
+		enableColor: enc.enableColor,
+		verboses:    enc.verboses,
+	}
+}
+
+func (enc *consoleEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := enc.textEncoder.cloned()
+	final.noLimit = true
+	if final.TimeKey != "" {
+		final.AppendTime(ent.Time)
+		final.buf.AppendByte(' ')
+	}
+	if final.LevelKey != "" {
+		enc.appendLevel(final, ent.Level)
+		final.buf.AppendByte(' ')
+	}
+	if ent.Caller.Defined && final.CallerKey != "" {
+		cur := final.buf.Len()
+		final.EncodeCaller(ent.Caller, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeCaller was a no-op. Fall back to strings.
+			final.AppendString(ent.Caller.String())
+		}
+		appendPadding(final.buf, DefaultConsoleCallerWidth-(final.buf.Len()-cur))
+		final.buf.AppendByte(' ')
+	}
+	if ent.Caller.Defined && final.FunctionKey != "" {
+		final.safeAddString(ent.Caller.Function)
+		final.buf.AppendByte(' ')
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.beginQuoteFiled()
+		final.safeAddString(ent.LoggerName)
+		final.endQuoteFiled()
+		final.buf.AppendByte(' ')
+	}
+	final.noLimit = false
+	// add seperator
+	if final.Seperator != "" {
+		final.buf.AppendString(final.Seperator)
+		final.buf.AppendByte(' ')
+	}
+	// add message, the message column is not wrapped with brackets in console format
+	if final.MessageKey != "" {
+		final.safeAddString(final.limiter.truncateMessage(ent.Message))
+	}
+	if enc.buf.Len() > 0 {
+		final.buf.AppendByte(' ')
+		_, _ = final.buf.Write(enc.buf.Bytes())
+	}
+
+	// limit the capacity, so appending to verboses never changes the shared array
+	verboses := enc.verboses[:len(enc.verboses):len(enc.verboses)]
+	for _, f := range fields {
+		if f.Type == zapcore.ErrorType {
+			verboses = final.encodeConsoleError(f, verboses)
+			continue
+		}
+		final.buf.AppendByte(' ')
+		final.beginField()
+		f.AddTo(final)
+		final.endField()
+	}
+	final.closeOpenNamespaces()
+
+	lineEnding := final.lineEnding()
+	final.buf.AppendString(lineEnding)
+
+	for _, verbose := range verboses {
+		appendIndentedBlock(final.buf, verbose, lineEnding)
+	}
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		appendIndentedBlock(final.buf, final.StacktraceKey+":\n"+final.consoleStack(ent.Stack), lineEnding)
+	}
+	final.truncateEntry()
+
+	ret := final.buf
+	putTextEncoder(final)
+	return ret, nil
+}
+
+// appendLevel appends the level wrapped with brackets, and colorizes it if color is enabled
+func (enc *consoleEncoder) appendLevel(final *textEncoder, level zapcore.Level) {
+	if enc.enableColor {
+		final.buf.AppendString(levelColor(level))
+	}
+	cur := final.buf.Len()
+	final.beginQuoteFiled()
+	final.EncodeLevel(level, final)
+	if cur+1 == final.buf.Len() {
+		// User-supplied EncodeLevel was a no-op. Fall back to strings.
+		final.AppendString(level.String())
+	}
+	final.endQuoteFiled()
+	width := visibleWidth(final.buf.Bytes()[cur:])
+	if enc.enableColor {
+		final.buf.AppendString(colorReset)
+	}
+	// pad the level with the brackets to the same width
+	appendPadding(final.buf, consoleLevelWidth+2-width)
+}
+
+// addFields adds the fields to the encoder, the verbose errors are kept
+// and will be printed as indented blocks after the log line
+func (enc *consoleEncoder) addFields(fields []zapcore.Field) {
+	for _, f := range fields {
+		if f.Type == zapcore.ErrorType {
+			enc.verboses = enc.encodeConsoleError(f, enc.verboses[:len(enc.verboses):len(enc.verboses)])
+			continue
+		}
+		if enc.buf.Len() > 0 {
+			enc.buf.AppendByte(' ')
+		}
+		enc.beginField()
+		f.AddTo(enc.textEncoder)
+		enc.endField()
+	}
+}
+
+// encodeConsoleError adds the error message of the field to the buffer,
+// and returns the verboses with the verbose error appended
+func (enc *textEncoder) encodeConsoleError(f zapcore.Field, verboses []string) []string {
+	err := f.Interface.(error)
+	basic := err.Error()
+	if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+	enc.beginField()
+	enc.AddString(f.Key, basic)
+	enc.endField()
+	if enc.disableErrorVerbose || enc.isRedacted(f.Key) {
+		return verboses
+	}
+	if enc.errorEncoder == ErrorEncoderChain {
+		verbose := f.Key + errorChainSuffix + ":\n" + strings.Join(newErrorChain(err, enc.stackFormatter).lines(), "\n")
+		if enc.scanner != nil {
+			verbose = enc.scanner.scan(verbose)
+		}
+		return append(verboses, enc.limiter.truncateField(verbose))
+	}
+	if e, isFormatter := err.(fmt.Formatter); isFormatter {
+		verbose := fmt.Sprintf("%+v", e)
+		if verbose != basic {
+			if enc.scanner != nil {
+				// the verbose blocks are appended to the buffer directly
+				verbose = enc.scanner.scan(verbose)
+			}
+			verbose = enc.limiter.truncateField(verbose)
+			return append(verboses, f.Key+"Verbose:\n"+verbose)
+		}
+	}
+
+	return verboses
+}
+
+// consoleStack returns the filtered stack, the structured stack has one frame per line
+func (enc *textEncoder) consoleStack(stack string) string {
+	if enc.stackFormatter == nil {
+		return stack
+	}
+	frames, ok := enc.stackFormatter.frames(stack)
+	if !ok {
+		return stack
+	}
+	if enc.stackFormatter.structured {
+		return strings.Join(frames.lines(), "\n")
+	}
+
+	return frames.String()
+}
+
+// appendIndentedBlock appends each line of s to the buffer with indent,
+// the first line is the title of the block and is indented once, the others are indented twice
+func appendIndentedBlock(buf *buffer.Buffer, s, lineEnding string) {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	for i, line := range lines {
+		buf.AppendString(consoleIndent)
+		if i > 0 {
+			buf.AppendString(consoleIndent)
+		}
+		buf.AppendString(strings.TrimRight(line, "\r"))
+		buf.AppendString(lineEnding)
+	}
+}
+
+// visibleWidth returns the number of bytes of b without the ANSI color codes
+func visibleWidth(b []byte) int {
+	width := 0
+	for i := 0; i < len(b); i++ {
+		if b[i] == '\x1b' {
+			for i < len(b) && b[i] != 'm' {
+				i++
+			}
+			continue
+		}
+		width++
+	}
+
+	return width
+}
+
+// appendPadding appends n spaces to the buffer
+func appendPadding(buf *buffer.Buffer, n int) {
+	for i := 0; i < n; i++ {
+		buf.AppendByte(' ')
+	}
+}
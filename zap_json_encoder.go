@@ -0,0 +1,858 @@
+// Copyright (c) 2016 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package log
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var _jsonPool = sync.Pool{New: func() interface{} {
+	return &jsonEncoder{}
+}}
+
+var nullLiteralBytes = []byte("null")
+
+func getJSONEncoder() *jsonEncoder {
+	return _jsonPool.Get().(*jsonEncoder)
+}
+
+func putJSONEncoder(enc *jsonEncoder) {
+	if enc.reflectBuf != nil {
+		enc.reflectBuf.Free()
+	}
+	enc.EncoderConfig = nil
+	enc.buf = nil
+	enc.spaced = false
+	enc.openNamespaces = 0
+	enc.reflectBuf = nil
+	enc.reflectEnc = nil
+	enc.redactor = nil
+	enc.redactAll = false
+	enc.scanner = nil
+	enc.limiter = nil
+	enc.noLimit = false
+	enc.stackFormatter = nil
+	enc.shared = nil
+	_jsonPool.Put(enc)
+}
+
+// jsonEncoder is a copy of zapcore.jsonEncoder which respects the options of Config,
+// it writes one json object per line
+type jsonEncoder struct {
+	*zapcore.EncoderConfig
+	buf                 *buffer.Buffer
+	spaced              bool // include spaces after colons and commas
+	openNamespaces      int
+	disableErrorVerbose bool
+	redactor            *redactor
+	// redactAll is true if a redacted namespace is opened, all the values in the namespace will be redacted
+	redactAll bool
+	// scanner masks the secrets in the messages and the string values, nil means scanning is disabled
+	scanner *secretScanner
+	// limiter truncates the values which exceed the size limits, nil means no limit
+	limiter *limiter
+	// noLimit is true while the header items like the time, the level and the caller are encoded,
+	// MaxFieldLength only applies to the field values
+	noLimit bool
+	// stackFormatter filters and formats the stack traces, nil means the stack traces are kept as they are
+	stackFormatter *stackFormatter
+
+	// for encoding generic values by reflection
+	reflectBuf *buffer.Buffer
+	reflectEnc *json.Encoder
+	TimeFormat string
+	// timeLocation is the time zone of the timestamps, nil means the local time zone
+	timeLocation *time.Location
+	// callerEncoder is the name of the caller encoder used by DefaultCallerEncoder
+	callerEncoder string
+	// shared holds the latest time format and caller encoder, the clones load them from it
+	shared *sharedJSONSettings
+	// errorEncoder is the name of the error encoder, which decides how the error fields are encoded
+	errorEncoder string
+	// useTextMarshaler and useStringer enable the fallbacks of the reflected values, see getTypeEncoder()
+	useTextMarshaler bool
+	useStringer      bool
+}
+
+// NewJSONEncoder creates a fast, low-allocation JSON encoder. The encoder
+// appropriately escapes all field keys and values.
+func NewJSONEncoder(cfg *Config) zapcore.Encoder {
+	// the error of the time zone is checked by newZapEncoder()
+	loc, _ := newTimeLocation(cfg.TimeZone)
+
+	cc := newZapEncoderConfig(cfg)
+	settings := jsonSettings{
+		TimeFormat:    getTimeFormat(cfg.TimeFormat),
+		callerEncoder: strings.ToLower(cfg.Encoder.CallerEncoder),
+	}
+	return &jsonEncoder{
+		EncoderConfig:       &cc,
+		buf:                 _pool.Get(),
+		spaced:              false,
+		disableErrorVerbose: cfg.DisableErrorVerbose,
+		redactor:            newRedactor(cfg.Redact),
+		scanner:             newSecretScanner(cfg.Scan),
+		limiter:             newLimiter(cfg.Limit),
+		stackFormatter:      newStackFormatter(cfg.Stack),
+		TimeFormat:          settings.TimeFormat,
+		timeLocation:        loc,
+		callerEncoder:       settings.callerEncoder,
+		shared:              newSharedJSONSettings(settings),
+		errorEncoder:        strings.ToLower(cfg.Encoder.ErrorEncoder),
+		useTextMarshaler:    cfg.Encoder.UseTextMarshaler,
+		useStringer:         cfg.Encoder.UseStringer,
+	}
+}
+
+// detachSettings gives the encoder its own copy of the shared settings
+func (enc *jsonEncoder) detachSettings() {
+	enc.shared = newSharedJSONSettings(enc.shared.load())
+}
+
+// SetTimeFormat sets the time format to the encoder
+func (enc *jsonEncoder) SetTimeFormat(timeFormat string) error {
+	enc.shared.update(func(settings *jsonSettings) {
+		settings.TimeFormat = getTimeFormat(timeFormat)
+	})
+	return nil
+}
+
+// SetCallerEncoder sets the caller encoder to the encoder
+func (enc *jsonEncoder) SetCallerEncoder(callerEncoder string) error {
+	err := validateCallerEncoder(callerEncoder)
+	if err != nil {
+		return err
+	}
+
+	enc.shared.update(func(settings *jsonSettings) {
+		settings.callerEncoder = strings.ToLower(callerEncoder)
+	})
+	return nil
+}
+
+// SetSeperator returns an error, as the json encoder has no seperator
+func (enc *jsonEncoder) SetSeperator(seperator string) error {
+	return newErrNotSupportedByEncoder("seperator", LogFormatJSON)
+}
+
+// SetDisableDoubleQuotes returns an error, as json strings are always wrapped with double quotes
+func (enc *jsonEncoder) SetDisableDoubleQuotes(disableDoubleQuotes bool) error {
+	return newErrNotSupportedByEncoder("disabling double quotes", LogFormatJSON)
+}
+
+// SetDisableEscape returns an error, as json strings are always escaped
+func (enc *jsonEncoder) SetDisableEscape(disableEscape bool) error {
+	return newErrNotSupportedByEncoder("disabling escape", LogFormatJSON)
+}
+
+// secretScanner returns the scanner of the encoder, nil means scanning is disabled
+func (enc *jsonEncoder) secretScanner() *secretScanner {
+	return enc.scanner
+}
+
+func (enc *jsonEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return nil
+	}
+	enc.addKey(key)
+	return enc.AppendArray(arr)
+}
+
+func (enc *jsonEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return nil
+	}
+	enc.addKey(key)
+	return enc.AppendObject(obj)
+}
+
+func (enc *jsonEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *jsonEncoder) AddByteString(key string, val []byte) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, string(val))
+		return
+	}
+	enc.addKey(key)
+	enc.AppendByteString(val)
+}
+
+func (enc *jsonEncoder) AddBool(key string, val bool) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
+	enc.addKey(key)
+	enc.AppendBool(val)
+}
+
+func (enc *jsonEncoder) AddComplex128(key string, val complex128) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
+	enc.addKey(key)
+	enc.AppendComplex128(val)
+}
+
+func (enc *jsonEncoder) AddDuration(key string, val time.Duration) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
+	enc.addKey(key)
+	enc.AppendDuration(val)
+}
+
+func (enc *jsonEncoder) AddFloat64(key string, val float64) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, strconv.FormatFloat(val, 'g', -1, 64))
+		return
+	}
+	enc.addKey(key)
+	enc.AppendFloat64(val)
+}
+
+func (enc *jsonEncoder) AddInt64(key string, val int64) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, strconv.FormatInt(val, 10))
+		return
+	}
+	enc.addKey(key)
+	enc.AppendInt64(val)
+}
+
+func (enc *jsonEncoder) resetReflectBuf() {
+	if enc.reflectBuf == nil {
+		enc.reflectBuf = _pool.Get()
+		enc.reflectEnc = json.NewEncoder(enc.reflectBuf)
+	} else {
+		enc.reflectBuf.Reset()
+	}
+}
+
+// Only invoke the standard JSON encoder if there is actually something to
+// encode; otherwise write JSON null literal directly.
+func (enc *jsonEncoder) encodeReflected(obj interface{}) ([]byte, error) {
+	if obj == nil {
+		return nullLiteralBytes, nil
+	}
+	enc.resetReflectBuf()
+	err := enc.reflectEnc.Encode(obj)
+	if err != nil {
+		return nil, err
+	}
+	enc.reflectBuf.TrimNewline()
+	return enc.reflectBuf.Bytes(), nil
+}
+
+func (enc *jsonEncoder) AddReflected(key string, obj interface{}) error {
+	if !enc.isRedacted(key) {
+		if encode := getTypeEncoder(obj, enc.useTextMarshaler, enc.useStringer); encode != nil {
+			enc.addKey(key)
+			encode(obj, enc)
+			return nil
+		}
+	}
+	valueBytes, err := enc.encodeReflected(obj)
+	if err != nil {
+		return err
+	}
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, string(valueBytes))
+		return nil
+	}
+	enc.addKey(key)
+	if enc.fieldLimiter().fieldExceeded(len(valueBytes)) {
+		// the truncated json is not valid, so it is added as a string
+		enc.AppendByteString(valueBytes)
+		return nil
+	}
+	_, err = enc.buf.Write(valueBytes)
+	return err
+}
+
+func (enc *jsonEncoder) OpenNamespace(key string) {
+	if enc.isRedacted(key) {
+		enc.redactAll = true
+	}
+	enc.addKey(key)
+	enc.buf.AppendByte('{')
+	enc.openNamespaces++
+}
+
+func (enc *jsonEncoder) AddString(key, val string) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, val)
+		return
+	}
+	enc.addKey(key)
+	enc.AppendString(val)
+}
+
+func (enc *jsonEncoder) AddTime(key string, val time.Time) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
+	enc.addKey(key)
+	enc.AppendTime(val)
+}
+
+func (enc *jsonEncoder) AddUint64(key string, val uint64) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, strconv.FormatUint(val, 10))
+		return
+	}
+	enc.addKey(key)
+	enc.AppendUint64(val)
+}
+
+func (enc *jsonEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('[')
+	var err error
+	if la := enc.fieldLimiter().limitArray(enc); la != nil {
+		err = arr.MarshalLogArray(la)
+		if marker := la.marker(); marker != "" {
+			enc.addElementSeparator()
+			enc.buf.AppendByte('"')
+			enc.safeAddString(marker)
+			enc.buf.AppendByte('"')
+		}
+	} else {
+		err = arr.MarshalLogArray(enc)
+	}
+	enc.buf.AppendByte(']')
+	return err
+}
+
+func (enc *jsonEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	// Close ONLY new openNamespaces that are created during
+	// AppendObject().
+	old := enc.openNamespaces
+	oldRedactAll := enc.redactAll
+	enc.openNamespaces = 0
+	enc.addElementSeparator()
+	enc.buf.AppendByte('{')
+	err := obj.MarshalLogObject(enc)
+	enc.buf.AppendByte('}')
+	enc.closeOpenNamespaces()
+	enc.openNamespaces = old
+	// the namespaces opened in the object are closed, so as the redaction of them
+	enc.redactAll = oldRedactAll
+	return err
+}
+
+func (enc *jsonEncoder) AppendBool(val bool) {
+	enc.addElementSeparator()
+	enc.buf.AppendBool(val)
+}
+
+func (enc *jsonEncoder) AppendByteString(val []byte) {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('"')
+	enc.safeAddByteString(enc.fieldLimiter().truncateFieldBytes(val))
+	enc.buf.AppendByte('"')
+}
+
+// appendComplex appends the encoded form of the provided complex128 value.
+// precision specifies the encoding precision for the real and imaginary
+// components of the complex number.
+func (enc *jsonEncoder) appendComplex(val complex128, precision int) {
+	enc.addElementSeparator()
+	// Cast to a platform-independent, fixed-size type.
+	r, i := float64(real(val)), float64(imag(val))
+	enc.buf.AppendByte('"')
+	// Because we're always in a quoted string, we can use strconv without
+	// special-casing NaN and +/-Inf.
+	enc.buf.AppendFloat(r, precision)
+	// If imaginary part is less than 0, minus (-) sign is added by default
+	// by AppendFloat.
+	if i >= 0 {
+		enc.buf.AppendByte('+')
+	}
+	enc.buf.AppendFloat(i, precision)
+	enc.buf.AppendByte('i')
+	enc.buf.AppendByte('"')
+}
+
+func (enc *jsonEncoder) AppendDuration(val time.Duration) {
+	cur := enc.buf.Len()
+	if e := enc.EncodeDuration; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		// User-supplied EncodeDuration is a no-op. Fall back to nanoseconds to keep
+		// JSON valid.
+		enc.AppendInt64(int64(val))
+	}
+}
+
+func (enc *jsonEncoder) AppendInt64(val int64) {
+	enc.addElementSeparator()
+	enc.buf.AppendInt(val)
+}
+
+func (enc *jsonEncoder) AppendReflected(val interface{}) error {
+	if encode := getTypeEncoder(val, enc.useTextMarshaler, enc.useStringer); encode != nil {
+		encode(val, enc)
+		return nil
+	}
+	valueBytes, err := enc.encodeReflected(val)
+	if err != nil {
+		return err
+	}
+	if enc.fieldLimiter().fieldExceeded(len(valueBytes)) {
+		// the truncated json is not valid, so it is appended as a string
+		enc.AppendByteString(valueBytes)
+		return nil
+	}
+	enc.addElementSeparator()
+	_, err = enc.buf.Write(valueBytes)
+	return err
+}
+
+func (enc *jsonEncoder) AppendString(val string) {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('"')
+	enc.safeAddString(enc.fieldLimiter().truncateField(val))
+	enc.buf.AppendByte('"')
+}
+
+func (enc *jsonEncoder) AppendTime(val time.Time) {
+	cur := enc.buf.Len()
+	if e := enc.EncodeTime; e != nil {
+		e(val, enc)
+	}
+	if cur == enc.buf.Len() {
+		// User-supplied EncodeTime is a no-op. Fall back to nanos since epoch to keep
+		// output JSON valid.
+		enc.AppendInt64(val.UnixNano())
+	}
+}
+
+func (enc *jsonEncoder) AppendUint64(val uint64) {
+	enc.addElementSeparator()
+	enc.buf.AppendUint(val)
+}
+
+func (enc *jsonEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *jsonEncoder) AddFloat32(k string, v float32)     { enc.AddFloat64(k, float64(v)) }
+func (enc *jsonEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *jsonEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *jsonEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *jsonEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *jsonEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *jsonEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *jsonEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *jsonEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *jsonEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+func (enc *jsonEncoder) AppendComplex64(v complex64)        { enc.appendComplex(complex128(v), 32) }
+func (enc *jsonEncoder) AppendComplex128(v complex128)      { enc.appendComplex(v, 64) }
+func (enc *jsonEncoder) AppendFloat64(v float64)            { enc.appendFloat(v, 64) }
+func (enc *jsonEncoder) AppendFloat32(v float32)            { enc.appendFloat(float64(v), 32) }
+func (enc *jsonEncoder) AppendInt(v int)                    { enc.AppendInt64(int64(v)) }
+func (enc *jsonEncoder) AppendInt32(v int32)                { enc.AppendInt64(int64(v)) }
+func (enc *jsonEncoder) AppendInt16(v int16)                { enc.AppendInt64(int64(v)) }
+func (enc *jsonEncoder) AppendInt8(v int8)                  { enc.AppendInt64(int64(v)) }
+func (enc *jsonEncoder) AppendUint(v uint)                  { enc.AppendUint64(uint64(v)) }
+func (enc *jsonEncoder) AppendUint32(v uint32)              { enc.AppendUint64(uint64(v)) }
+func (enc *jsonEncoder) AppendUint16(v uint16)              { enc.AppendUint64(uint64(v)) }
+func (enc *jsonEncoder) AppendUint8(v uint8)                { enc.AppendUint64(uint64(v)) }
+func (enc *jsonEncoder) AppendUintptr(v uintptr)            { enc.AppendUint64(uint64(v)) }
+
+func (enc *jsonEncoder) Clone() zapcore.Encoder {
+	clone := enc.cloned()
+	_, _ = clone.buf.Write(enc.buf.Bytes())
+	return clone
+}
+
+func (enc *jsonEncoder) cloned() *jsonEncoder {
+	clone := getJSONEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.spaced = enc.spaced
+	clone.openNamespaces = enc.openNamespaces
+	clone.disableErrorVerbose = enc.disableErrorVerbose
+	clone.redactor = enc.redactor
+	clone.redactAll = enc.redactAll
+	clone.scanner = enc.scanner
+	clone.limiter = enc.limiter
+	clone.stackFormatter = enc.stackFormatter
+	clone.buf = _pool.Get()
+	// the time format and the caller encoder could be changed at runtime, the latest ones are loaded
+	settings := enc.shared.load()
+	clone.TimeFormat = settings.TimeFormat
+	clone.timeLocation = enc.timeLocation
+	clone.callerEncoder = settings.callerEncoder
+	clone.shared = enc.shared
+	clone.errorEncoder = enc.errorEncoder
+	clone.useTextMarshaler = enc.useTextMarshaler
+	clone.useStringer = enc.useStringer
+	return clone
+}
+
+// fieldLimiter returns the limiter of the field values, it is nil while the header items are encoded
+func (enc *jsonEncoder) fieldLimiter() *limiter {
+	if enc.noLimit {
+		return nil
+	}
+
+	return enc.limiter
+}
+
+func (enc *jsonEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := enc.cloned()
+	msg := final.limiter.truncateMessage(ent.Message)
+	final.encodeEntry(enc, ent, msg, fields, 0)
+	if final.limiter.entryExceeded(final.buf.Len()) {
+		// drop the fields and the stack, and truncate the message if it is still too large,
+		// so that the entry is always a complete json object
+		atomic.AddInt64(&_truncationEntry, 1)
+		size := final.buf.Len()
+		final.limiter.fitMessage(ent.Message, msg, func(msg string) int {
+			final.buf.Reset()
+			final.openNamespaces = 0
+			final.encodeEntry(nil, ent, msg, nil, size)
+			return final.buf.Len()
+		})
+	}
+
+	ret := final.buf
+	putJSONEncoder(final)
+	return ret, nil
+}
+
+// encodeEntry encodes the entry to the buffer, enc is the encoder with the fields added by With(),
+// msg is the message which has been truncated by the limiter,
+// if size is larger than 0, enc, the fields and the stack are dropped and a truncated marker is added,
+// size is the size of the entry before dropping
+func (final *jsonEncoder) encodeEntry(enc *jsonEncoder, ent zapcore.Entry, msg string, fields []zapcore.Field, size int) {
+	final.buf.AppendByte('{')
+
+	final.noLimit = true
+	if final.LevelKey != "" && final.EncodeLevel != nil {
+		final.addKey(final.LevelKey)
+		cur := final.buf.Len()
+		final.EncodeLevel(ent.Level, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeLevel was a no-op. Fall back to strings to keep
+			// output JSON valid.
+			final.AppendString(ent.Level.String())
+		}
+	}
+	if final.TimeKey != "" && !ent.Time.IsZero() {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		cur := final.buf.Len()
+		nameEncoder := final.EncodeName
+
+		// if no name encoder provided, fall back to FullNameEncoder for backwards compatibility
+		if nameEncoder == nil {
+			nameEncoder = zapcore.FullNameEncoder
+		}
+
+		nameEncoder(ent.LoggerName, final)
+		if cur == final.buf.Len() {
+			// User-supplied EncodeName was a no-op. Fall back to strings to
+			// keep output JSON valid.
+			final.AppendString(ent.LoggerName)
+		}
+	}
+	if ent.Caller.Defined {
+		if final.CallerKey != "" {
+			final.addKey(final.CallerKey)
+			cur := final.buf.Len()
+			final.EncodeCaller(ent.Caller, final)
+			if cur == final.buf.Len() {
+				// User-supplied EncodeCaller was a no-op. Fall back to strings to
+				// keep output JSON valid.
+				final.AppendString(ent.Caller.String())
+			}
+		}
+		if final.FunctionKey != "" {
+			final.addKey(final.FunctionKey)
+			final.AppendString(ent.Caller.Function)
+		}
+	}
+	final.noLimit = false
+	if final.MessageKey != "" {
+		final.addKey(final.MessageKey)
+		final.buf.AppendByte('"')
+		final.safeAddString(msg)
+		final.buf.AppendByte('"')
+	}
+	if size > 0 {
+		final.addKey(DefaultTruncatedKey)
+		final.buf.AppendByte('"')
+		final.buf.AppendString(truncatedMarker(size-final.buf.Len()-1, truncatedMarkerBytes))
+		final.buf.AppendByte('"')
+		final.buf.AppendByte('}')
+		final.buf.AppendString(final.lineEnding())
+		return
+	}
+	if enc.buf.Len() > 0 {
+		final.addElementSeparator()
+		_, _ = final.buf.Write(enc.buf.Bytes())
+	}
+	final.addFields(fields)
+	final.closeOpenNamespaces()
+	final.addStack(ent)
+	final.buf.AppendByte('}')
+	final.buf.AppendString(final.lineEnding())
+}
+
+// addStack adds the stack of the entry, the structured stack is added as an array of frames
+func (enc *jsonEncoder) addStack(ent zapcore.Entry) {
+	if ent.Stack == "" || enc.StacktraceKey == "" {
+		return
+	}
+	if enc.stackFormatter != nil {
+		frames, ok := enc.stackFormatter.frames(ent.Stack)
+		if ok && enc.stackFormatter.structured {
+			_ = enc.AddArray(enc.StacktraceKey, frames)
+			return
+		}
+		if ok {
+			enc.AddString(enc.StacktraceKey, frames.String())
+			return
+		}
+	}
+	enc.AddString(enc.StacktraceKey, ent.Stack)
+}
+
+// lineEnding returns the line ending of the encoder
+func (enc *jsonEncoder) lineEnding() string {
+	if enc.LineEnding != "" {
+		return enc.LineEnding
+	}
+
+	return zapcore.DefaultLineEnding
+}
+
+func (enc *jsonEncoder) truncate() {
+	enc.buf.Reset()
+}
+
+func (enc *jsonEncoder) closeOpenNamespaces() {
+	for i := 0; i < enc.openNamespaces; i++ {
+		enc.buf.AppendByte('}')
+	}
+	enc.openNamespaces = 0
+}
+
+func (enc *jsonEncoder) addKey(key string) {
+	enc.addElementSeparator()
+	enc.buf.AppendByte('"')
+	enc.safeAddString(key)
+	enc.buf.AppendByte('"')
+	enc.buf.AppendByte(':')
+	if enc.spaced {
+		enc.buf.AppendByte(' ')
+	}
+}
+
+func (enc *jsonEncoder) addElementSeparator() {
+	last := enc.buf.Len() - 1
+	if last < 0 {
+		return
+	}
+	switch enc.buf.Bytes()[last] {
+	case '{', '[', ':', ',', ' ':
+		return
+	default:
+		enc.buf.AppendByte(',')
+		if enc.spaced {
+			enc.buf.AppendByte(' ')
+		}
+	}
+}
+
+func (enc *jsonEncoder) appendFloat(val float64, bitSize int) {
+	enc.addElementSeparator()
+	switch {
+	case math.IsNaN(val):
+		enc.buf.AppendString(`"NaN"`)
+	case math.IsInf(val, 1):
+		enc.buf.AppendString(`"+Inf"`)
+	case math.IsInf(val, -1):
+		enc.buf.AppendString(`"-Inf"`)
+	default:
+		enc.buf.AppendFloat(val, bitSize)
+	}
+}
+
+// safeAddString JSON-escapes a string and appends it to the internal buffer.
+// Unlike the standard library's encoder, it doesn't attempt to protect the
+// user from browser vulnerabilities or JSONP-related problems.
+func (enc *jsonEncoder) safeAddString(s string) {
+	if enc.scanner != nil {
+		s = enc.scanner.scan(s)
+	}
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if enc.tryAddRuneError(r, size) {
+			i++
+			continue
+		}
+		enc.buf.AppendString(s[i : i+size])
+		i += size
+	}
+}
+
+// safeAddByteString is no-alloc equivalent of safeAddString(string(s)) for s []byte.
+func (enc *jsonEncoder) safeAddByteString(s []byte) {
+	if enc.scanner != nil {
+		// the secrets are scanned as a string
+		enc.safeAddString(string(s))
+		return
+	}
+	for i := 0; i < len(s); {
+		if enc.tryAddRuneSelf(s[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if enc.tryAddRuneError(r, size) {
+			i++
+			continue
+		}
+		_, _ = enc.buf.Write(s[i : i+size])
+		i += size
+	}
+}
+
+// tryAddRuneSelf appends b if it is valid UTF-8 character represented in a single byte.
+func (enc *jsonEncoder) tryAddRuneSelf(b byte) bool {
+	if b >= utf8.RuneSelf {
+		return false
+	}
+	if 0x20 <= b && b != '\\' && b != '"' {
+		enc.buf.AppendByte(b)
+		return true
+	}
+	switch b {
+	case '\\', '"':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte(b)
+	case '\n':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte('n')
+	case '\r':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte('r')
+	case '\t':
+		enc.buf.AppendByte('\\')
+		enc.buf.AppendByte('t')
+	default:
+		// Encode bytes < 0x20, except for the escape sequences above.
+		enc.buf.AppendString(`\u00`)
+		enc.buf.AppendByte(_hex[b>>4])
+		enc.buf.AppendByte(_hex[b&0xF])
+	}
+	return true
+}
+
+func (enc *jsonEncoder) tryAddRuneError(r rune, size int) bool {
+	if r == utf8.RuneError && size == 1 {
+		enc.buf.AppendString(`\ufffd`)
+		return true
+	}
+	return false
+}
+
+func (enc *jsonEncoder) addFields(fields []zapcore.Field) {
+	for _, f := range fields {
+		if f.Type == zapcore.ErrorType {
+			// handle ErrorType here to respect Config.DisableErrorVerbose
+			enc.encodeError(f)
+			continue
+		}
+		f.AddTo(enc)
+	}
+}
+
+func (enc *jsonEncoder) encodeError(f zapcore.Field) {
+	err := f.Interface.(error)
+	basic := err.Error()
+	if enc.isRedacted(f.Key) {
+		// the verbose error is omitted, as it contains the basic error message
+		enc.addRedacted(f.Key, basic)
+		return
+	}
+	enc.AddString(f.Key, basic)
+	if enc.disableErrorVerbose {
+		return
+	}
+	if enc.errorEncoder == ErrorEncoderChain {
+		_ = enc.AddArray(f.Key+errorChainSuffix, newErrorChain(err, enc.stackFormatter))
+		return
+	}
+	if e, isFormatter := err.(fmt.Formatter); isFormatter {
+		// the verbose error is formatted into a pooled buffer instead of a new string
+		verbose := _pool.Get()
+		_, _ = fmt.Fprintf(verbose, "%+v", e)
+		if string(verbose.Bytes()) != basic {
+			// This is a rich error type, like those produced by github.com/pkg/errors.
+			enc.AddByteString(f.Key+"Verbose", verbose.Bytes())
+		}
+		verbose.Free()
+	}
+}
+
+// isRedacted returns true if the value of the key should be redacted
+func (enc *jsonEncoder) isRedacted(key string) bool {
+	return enc.redactor != nil && (enc.redactAll || enc.redactor.match(key))
+}
+
+// addRedacted adds the key with the redacted value
+func (enc *jsonEncoder) addRedacted(key, val string) {
+	enc.addKey(key)
+	enc.AppendString(enc.redactor.redact(val))
+}
+
+// addMasked adds the key with the mask, it is used for the values which could not be hashed
+func (enc *jsonEncoder) addMasked(key string) {
+	enc.addKey(key)
+	enc.AppendString(enc.redactor.mask)
+}
@@ -13,22 +13,45 @@
 
 package log
 
-import "go.uber.org/zap/zapcore"
+import (
+	"sync/atomic"
 
-// textIOCore is a copy of zapcore.ioCore that only accept *textEncoder
+	"github.com/pingcap/errors"
+	"github.com/romberli/go-multierror"
+	"go.uber.org/zap/zapcore"
+)
+
+const ErrNotTextIOCore = "make sure the core of the logger is a *textIOCore"
+
+// textIOCore is a copy of zapcore.ioCore that only accept the Encoder of this package
 // it can be removed after https://github.com/uber-go/zap/pull/685 be merged
 type textIOCore struct {
 	zapcore.LevelEnabler
-	enc *textEncoder
+	enc Encoder
 	out zapcore.WriteSyncer
+	// outputs are the cores with their own encoders, write syncers and levels,
+	// the entries are written to this core and the outputs like a tee, they are shared by the clones made by With(),
+	// see addOutput() and detach()
+	outputs *sharedOutputs
+	// derived caches the outputs with the fields added by With(), see derivedOutputs()
+	derived atomic.Value
+	// hooks are called with the entries written by this core, they are shared by the clones made by With(),
+	// see AddHook() and detach()
+	hooks *hooks
+	// fields are the fields added by With(), they are passed to the hooks
+	fields []zapcore.Field
+	// dedup suppresses the duplicates, nil means deduplication is disabled, it is shared by the clones
+	dedup *deduper
 }
 
 // NewTextCore creates a Core that writes logs to a WriteSyncer.
-func NewTextCore(enc *textEncoder, ws zapcore.WriteSyncer, enab zapcore.LevelEnabler) zapcore.Core {
+func NewTextCore(enc Encoder, ws zapcore.WriteSyncer, enab zapcore.LevelEnabler) zapcore.Core {
 	return &textIOCore{
 		LevelEnabler: enab,
 		enc:          enc,
 		out:          ws,
+		outputs:      newSharedOutputs(),
+		hooks:        newHooks(),
 	}
 }
 
@@ -39,22 +62,54 @@ func (c *textIOCore) GetWriterSyncer() zapcore.WriteSyncer {
 func (c *textIOCore) With(fields []zapcore.Field) zapcore.Core {
 	clone := c.clone()
 	// it's different to ioCore, here call textEncoder#addFields to fix https://github.com/pingcap/log/issues/3
-	clone.enc.addFields(fields)
+	clone.addFields(fields)
 	return clone
 }
 
+// addFields adds the fields to the encoder, and keeps them for the hooks
+func (c *textIOCore) addFields(fields []zapcore.Field) {
+	c.enc.addFields(fields)
+	c.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+}
+
+// Enabled returns true if the level is enabled by this core or any of the outputs
+func (c *textIOCore) Enabled(level zapcore.Level) bool {
+	if c.LevelEnabler.Enabled(level) {
+		return true
+	}
+	for _, output := range c.outputs.load().cores {
+		if output.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c *textIOCore) Syncer() zapcore.WriteSyncer {
 	return c.out
 }
 
 func (c *textIOCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
-	if c.Enabled(ent.Level) {
-		return ce.AddCore(ent, c)
+	if c.LevelEnabler.Enabled(ent.Level) {
+		ce = ce.AddCore(ent, c)
+	}
+	// each output checks the level of its own
+	for _, output := range c.derivedOutputs() {
+		ce = output.Check(ent, ce)
 	}
 	return ce
 }
 
 func (c *textIOCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if c.dedup != nil && !c.dedup.pass(c, ent, fields) {
+		return nil
+	}
+
+	return c.write(ent, fields)
+}
+
+// write encodes the entry and writes it to the write syncer, then calls the hooks
+func (c *textIOCore) write(ent zapcore.Entry, fields []zapcore.Field) error {
 	buf, err := c.enc.EncodeEntry(ent, fields)
 	if err != nil {
 		return err
@@ -64,6 +119,10 @@ func (c *textIOCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
 	if err != nil {
 		return err
 	}
+	err = c.runHooks(ent, fields)
+	if err != nil {
+		return err
+	}
 	if ent.Level > zapcore.ErrorLevel {
 		// Since we may be crashing the program, sync the output. Ignore Sync
 		// errors, pending a clean solution to issue https://github.com/uber-go/zap/issues/370.
@@ -73,35 +132,94 @@ func (c *textIOCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
 }
 
 func (c *textIOCore) Sync() error {
-	return c.out.Sync()
+	var err *multierror.Error
+	if c.dedup != nil {
+		// the summaries of the duplicates are written before syncing
+		err = multierror.Append(err, c.dedup.flush())
+	}
+	err = multierror.Append(err, c.out.Sync())
+	// the outputs derived by With() share the write syncers and the dedup of the added ones
+	for _, output := range c.outputs.load().cores {
+		err = multierror.Append(err, output.Sync())
+	}
+	return err.ErrorOrNil()
 }
 
 func (c *textIOCore) clone() *textIOCore {
 	return &textIOCore{
 		LevelEnabler: c.LevelEnabler,
-		enc:          c.enc.Clone().(*textEncoder),
+		enc:          c.enc.Clone().(Encoder),
 		out:          c.out,
+		outputs:      c.outputs,
+		hooks:        c.hooks,
+		fields:       c.fields,
+		dedup:        c.dedup,
+	}
+}
+
+// AddHook adds a hook which is called with the entries of the levels written by this core,
+// empty levels means all the levels, the hook reaches the clones made by With() before and after it is added,
+// the outputs have their own hooks, an error is returned if any of the levels is not valid
+func (c *textIOCore) AddHook(hook Hook, levels ...zapcore.Level) error {
+	return c.hooks.add(hook, levels...)
+}
+
+// detach gives the core its own copies of the state shared by the clones, so changing the clone
+// does not affect the core it is cloned from, it must only be called with a new clone, see CloneLogger()
+func (c *textIOCore) detach() {
+	c.enc.detachSettings()
+	c.outputs = c.outputs.copied()
+	c.hooks = c.hooks.copied()
+}
+
+// runHooks calls the hooks with the entry, the fields added by With() are prepended to the fields of the entry
+func (c *textIOCore) runHooks(ent zapcore.Entry, fields []zapcore.Field) error {
+	if len(c.hooks.load()) == 0 {
+		return nil
+	}
+	if len(c.fields) > 0 {
+		fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
 	}
+
+	return c.hooks.run(ent, fields)
 }
 
 // SetTimeFormat sets the time format to the encoder
-func (c *textIOCore) SetTimeFormat(timeFormat string) {
-	c.enc.SetTimeFormat(timeFormat)
+func (c *textIOCore) SetTimeFormat(timeFormat string) error {
+	return c.enc.SetTimeFormat(timeFormat)
+}
+
+// SetCallerEncoder sets the caller encoder to the encoder
+func (c *textIOCore) SetCallerEncoder(callerEncoder string) error {
+	return c.enc.SetCallerEncoder(callerEncoder)
 }
 
 // SetSeperator sets the seperator to the encoder
-func (c *textIOCore) SetSeperator(seperator string) {
-	c.enc.SetSeperator(seperator)
+func (c *textIOCore) SetSeperator(seperator string) error {
+	return c.enc.SetSeperator(seperator)
 }
 
 // SetDisableDoubleQuotes disables wrapping log content with double quotes
-func (c *textIOCore) SetDisableDoubleQuotes(disableDoubleQuotes bool) {
-	c.enc.SetDisableDoubleQuotes(disableDoubleQuotes)
+func (c *textIOCore) SetDisableDoubleQuotes(disableDoubleQuotes bool) error {
+	return c.enc.SetDisableDoubleQuotes(disableDoubleQuotes)
 }
 
 // SetDisableEscape disables escaping special characters of log content like \n,\r...
-func (c *textIOCore) SetDisableEscape(disableEscape bool) {
-	c.enc.SetDisableEscape(disableEscape)
+func (c *textIOCore) SetDisableEscape(disableEscape bool) error {
+	return c.enc.SetDisableEscape(disableEscape)
+}
+
+// secretScanners returns the scanners of the encoders of the core and the outputs, the disabled ones are skipped
+func (c *textIOCore) secretScanners() []*secretScanner {
+	var scanners []*secretScanner
+	for _, core := range append([]*textIOCore{c}, c.outputs.load().cores...) {
+		s := core.enc.secretScanner()
+		if s != nil {
+			scanners = append(scanners, s)
+		}
+	}
+
+	return scanners
 }
 
 func (c *textIOCore) ListWriteSyncer() []zapcore.WriteSyncer {
@@ -118,3 +236,66 @@ func (c *textIOCore) AddWriteSyncer(ws zapcore.WriteSyncer) {
 	syncerList = append(syncerList, ws)
 	c.out = NewMultiWriteSyncer(syncerList...)
 }
+
+// addOutput adds an output with its own encoder, write syncer and level, the output reaches the clones
+// made by With() before and after it is added, the runtime setters and AddWriteSyncer() do not change the outputs,
+// the errors of the output are reported to the output of this core which is the error output of the logger
+func (c *textIOCore) addOutput(output *textIOCore) {
+	if output.dedup != nil {
+		output.dedup.errorOutput = c.out
+	}
+	c.outputs.add(output)
+}
+
+// derivedOutputs returns the outputs with the fields added by With(), they are derived from the added outputs
+// when the outputs are changed, and cached until the next change
+func (c *textIOCore) derivedOutputs() []*textIOCore {
+	set := c.outputs.load()
+	if len(c.fields) == 0 || len(set.cores) == 0 {
+		return set.cores
+	}
+	derived, ok := c.derived.Load().(*outputSet)
+	if ok && derived.base == set {
+		return derived.cores
+	}
+
+	derived = &outputSet{base: set, cores: make([]*textIOCore, len(set.cores))}
+	for i, output := range set.cores {
+		derived.cores[i] = output.clone()
+		derived.cores[i].addFields(c.fields)
+	}
+	c.derived.Store(derived)
+
+	return derived.cores
+}
+
+// ListOutputWriteSyncer lists the write syncers of the outputs
+func (c *textIOCore) ListOutputWriteSyncer() []zapcore.WriteSyncer {
+	var syncerList []zapcore.WriteSyncer
+	for _, output := range c.outputs.load().cores {
+		syncerList = append(syncerList, output.ListWriteSyncer()...)
+	}
+
+	return syncerList
+}
+
+// CoreUnwrapper is implemented by the cores which decorate another core, like the sampler of this package,
+// the runtime setters, AddWriteSyncer() and Rotate() unwrap the cores to find the *textIOCore
+type CoreUnwrapper interface {
+	// Unwrap returns the core which is decorated
+	Unwrap() zapcore.Core
+}
+
+// getTextIOCore returns the *textIOCore of given core, the decorating cores are unwrapped
+func getTextIOCore(core zapcore.Core) (*textIOCore, error) {
+	for {
+		switch c := core.(type) {
+		case *textIOCore:
+			return c, nil
+		case CoreUnwrapper:
+			core = c.Unwrap()
+		default:
+			return nil, errors.New(ErrNotTextIOCore)
+		}
+	}
+}
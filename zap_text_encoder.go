@@ -38,6 +38,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -51,28 +52,112 @@ const (
 	defaultLogTimeFormat  = TimeFormatMicroSecond
 	TimeFormatMicroSecond = "2006-01-02 15:04:05.000000"
 	TimeFormatMilliSecond = "2006-01-02 15:04:05.000"
+	TimeFormatRFC3339Nano = time.RFC3339Nano
+	TimeFormatISO8601     = "2006-01-02T15:04:05.000Z0700"
+	// TimeFormatUnix, TimeFormatUnixMilli and TimeFormatUnixMicro are not layouts,
+	// they encode the time as the integer number of seconds, milliseconds or microseconds since the unix epoch
+	TimeFormatUnix      = "unix"
+	TimeFormatUnixMilli = "unix-millis"
+	TimeFormatUnixMicro = "unix-micros"
 
 	DefaultLogSeparator = "->"
 )
 
-// DefaultTimeEncoder serializes time.Time to a human-readable formatted string
+// DefaultTimeEncoder serializes time.Time to a human-readable formatted string,
+// or to the number since the unix epoch if the time format is one of the unix formats,
+// the time is converted to the time zone of the encoder before formatting
 func DefaultTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
-	format := defaultLogTimeFormat
-	if enc.(*textEncoder).TimeFormat != "" {
-		format = enc.(*textEncoder).TimeFormat
-	}
-	s := t.Format(format)
-	if e, ok := enc.(*textEncoder); ok {
-		for _, c := range []byte(s) {
-			e.buf.AppendByte(c)
+	var (
+		format string
+		loc    *time.Location
+	)
+	switch e := enc.(type) {
+	case *textEncoder:
+		format, loc = e.TimeFormat, e.timeLocation
+	case *jsonEncoder:
+		format, loc = e.TimeFormat, e.timeLocation
+	}
+	if format == "" {
+		format = defaultLogTimeFormat
+	}
+	if loc != nil {
+		t = t.In(loc)
+	}
+
+	switch format {
+	case TimeFormatUnix:
+		enc.AppendInt64(t.Unix())
+	case TimeFormatUnixMilli:
+		enc.AppendInt64(t.UnixNano() / int64(time.Millisecond))
+	case TimeFormatUnixMicro:
+		enc.AppendInt64(t.UnixNano() / int64(time.Microsecond))
+	default:
+		switch e := enc.(type) {
+		case *textEncoder:
+			// the formatted time is appended without quotes
+			e.addElementSeparator()
+			e.buf.AppendTime(t, format)
+			return
+		case *jsonEncoder:
+			if e.scanner == nil && e.fieldLimiter() == nil {
+				// the formatted time is appended to the buffer directly without allocation
+				e.addElementSeparator()
+				e.buf.AppendByte('"')
+				e.buf.AppendTime(t, format)
+				e.buf.AppendByte('"')
+				return
+			}
 		}
-		return
+		enc.AppendString(t.Format(format))
 	}
-	enc.AppendString(s)
+}
+
+// getTimeFormat returns the time format with given name, the names rfc3339nano and iso8601 are
+// case-insensitive and are converted to the layouts, other names are returned as they are
+func getTimeFormat(name string) string {
+	switch strings.ToLower(name) {
+	case "rfc3339nano":
+		return TimeFormatRFC3339Nano
+	case "iso8601":
+		return TimeFormatISO8601
+	default:
+		return name
+	}
+}
+
+// newTimeLocation returns the location with given name, empty name or Local returns nil,
+// which means the local time zone
+func newTimeLocation(name string) (*time.Location, error) {
+	if name == "" || name == "Local" {
+		return nil, nil
+	}
+
+	return time.LoadLocation(name)
 }
 
 // ShortCallerEncoder serializes a caller in file:line format.
 func ShortCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	if !caller.Defined {
+		enc.AppendString(getCallerString(caller))
+		return
+	}
+	// the short caller never needs quotes or escaping, so it is appended to the buffer directly without allocation
+	switch e := enc.(type) {
+	case *textEncoder:
+		if e.scanner == nil && e.fieldLimiter() == nil {
+			e.addElementSeparator()
+			appendShortCaller(e.buf, caller)
+			return
+		}
+	case *jsonEncoder:
+		if e.scanner == nil && e.fieldLimiter() == nil {
+			e.addElementSeparator()
+			e.buf.AppendByte('"')
+			appendShortCaller(e.buf, caller)
+			e.buf.AppendByte('"')
+			return
+		}
+	}
 	enc.AppendString(getCallerString(caller))
 }
 
@@ -81,8 +166,17 @@ func getCallerString(ec zapcore.EntryCaller) string {
 		return "<unknown>"
 	}
 
-	idx := strings.LastIndexByte(ec.File, '/')
 	buf := _pool.Get()
+	appendShortCaller(buf, ec)
+	caller := buf.String()
+	buf.Free()
+	return caller
+}
+
+// appendShortCaller appends the caller in file:line format, the file is the base name of the file,
+// and the characters other than letters, digits, dots, dashes and underscores are removed
+func appendShortCaller(buf *buffer.Buffer, ec zapcore.EntryCaller) {
+	idx := strings.LastIndexByte(ec.File, '/')
 	for i := idx + 1; i < len(ec.File); i++ {
 		b := ec.File[i]
 		switch {
@@ -99,9 +193,6 @@ func getCallerString(ec zapcore.EntryCaller) string {
 	}
 	buf.AppendByte(':')
 	buf.AppendInt(int64(ec.Line))
-	caller := buf.String()
-	buf.Free()
-	return caller
 }
 
 // For JSON-escaping; see textEncoder.safeAddString below.
@@ -125,87 +216,212 @@ func putTextEncoder(enc *textEncoder) {
 	if enc.reflectBuf != nil {
 		enc.reflectBuf.Free()
 	}
-	enc.EncoderConfig = nil
+	enc.textSettings = nil
+	enc.shared = nil
 	enc.buf = nil
-	enc.spaced = false
 	enc.openNamespaces = 0
 	enc.reflectBuf = nil
 	enc.reflectEnc = nil
+	enc.redactAll = false
+	enc.dottedKeys = false
+	enc.namespace = ""
+	enc.fieldOpen = false
+	enc.noLimit = false
 	_textPool.Put(enc)
 }
 
-type textEncoder struct {
+// textSettings is the configuration of the text encoder, it is shared by the clones of the encoder,
+// so EncodeEntry() does not copy the configuration for each entry,
+// it is an immutable snapshot once being shared, use updateSettings() to change it
+type textSettings struct {
 	*zapcore.EncoderConfig
-	buf                 *buffer.Buffer
 	spaced              bool // include spaces after colons and commas
-	openNamespaces      int
 	disableErrorVerbose bool
+	redactor            *redactor
+	// scanner masks the secrets in the messages and the string values, nil means scanning is disabled
+	scanner *secretScanner
+	// limiter truncates the values which exceed the size limits, nil means no limit
+	limiter *limiter
+	// stackFormatter filters and formats the stack traces, nil means the stack traces are kept as they are
+	stackFormatter *stackFormatter
 
-	// for encoding generic values by reflection
-	reflectBuf          *buffer.Buffer
-	reflectEnc          *json.Encoder
 	TimeFormat          string
 	Seperator           string
 	DisableDoubleQuotes bool
 	DisableEscape       bool
+
+	// timeLocation is the time zone of the timestamps, nil means the local time zone
+	timeLocation *time.Location
+	// callerEncoder is the name of the caller encoder used by DefaultCallerEncoder
+	callerEncoder string
+	// errorEncoder is the name of the error encoder, which decides how the error fields are encoded
+	errorEncoder string
+	// useTextMarshaler and useStringer enable the fallbacks of the reflected values, see getTypeEncoder()
+	useTextMarshaler bool
+	useStringer      bool
+	// layout is the parsed pattern layout, nil means the default bracketed layout
+	layout []layoutToken
+}
+
+type textEncoder struct {
+	*textSettings
+	// shared holds the latest settings, the clones load them from it
+	shared         *sharedTextSettings
+	buf            *buffer.Buffer
+	openNamespaces int
+	// redactAll is true if a redacted namespace is opened, all the values in the namespace will be redacted
+	redactAll bool
+
+	// for encoding generic values by reflection
+	reflectBuf *buffer.Buffer
+	reflectEnc *json.Encoder
+
+	// dottedKeys flattens the namespaces and the nested objects into dotted keys
+	dottedKeys bool
+	// namespace is the prefix of the dotted keys, it ends with a dot if it is not empty
+	namespace string
+	// fieldOpen is true if a bracket of the dotted keys is opened and not closed yet
+	fieldOpen bool
+	// noLimit is true while the header items like the time, the level and the caller are encoded,
+	// MaxFieldLength only applies to the field values
+	noLimit bool
 }
 
 // NewTextEncoder creates a fast, low-allocation Text encoder. The encoder
 // appropriately escapes all field keys and values.
+// If Config.Layout is not valid, it falls back to the default layout.
 func NewTextEncoder(cfg *Config) zapcore.Encoder {
-	cc := zapcore.EncoderConfig{
-		// Keys can be anything except the empty string.
-		TimeKey:        "time",
-		LevelKey:       "level",
-		NameKey:        "name",
-		CallerKey:      "caller",
-		MessageKey:     "message",
-		StacktraceKey:  "stack",
-		LineEnding:     zapcore.DefaultLineEnding,
-		EncodeLevel:    zapcore.CapitalLevelEncoder,
-		EncodeTime:     DefaultTimeEncoder,
-		EncodeDuration: zapcore.StringDurationEncoder,
-		EncodeCaller:   ShortCallerEncoder,
-	}
-	if cfg.DisableTimestamp {
-		cc.TimeKey = ""
+	return newLayoutTextEncoder(cfg)
+}
+
+// newLayoutTextEncoder returns a *textEncoder with the pattern layout of the config
+func newLayoutTextEncoder(cfg *Config) *textEncoder {
+	var layout []layoutToken
+	if cfg.Layout != "" {
+		// the error of the layout is checked by newZapEncoder()
+		layout, _ = parseLayout(cfg.Layout)
 	}
-	return &textEncoder{
+
+	return newTextEncoder(cfg, layout)
+}
+
+// newTextEncoder returns a *textEncoder with the layout, nil layout means the default bracketed layout,
+// the other encoders embed it without the pattern layout
+func newTextEncoder(cfg *Config, layout []layoutToken) *textEncoder {
+	// the error of the time zone is checked by newZapEncoder()
+	loc, _ := newTimeLocation(cfg.TimeZone)
+
+	cc := newZapEncoderConfig(cfg)
+	settings := &textSettings{
 		EncoderConfig:       &cc,
-		buf:                 _pool.Get(),
 		spaced:              false,
 		disableErrorVerbose: cfg.DisableErrorVerbose,
+		redactor:            newRedactor(cfg.Redact),
+		scanner:             newSecretScanner(cfg.Scan),
+		limiter:             newLimiter(cfg.Limit),
+		stackFormatter:      newStackFormatter(cfg.Stack),
 		DisableDoubleQuotes: cfg.DisableDoubleQuotes,
 		DisableEscape:       cfg.DisableEscape,
+		TimeFormat:          getTimeFormat(cfg.TimeFormat),
+		timeLocation:        loc,
+		callerEncoder:       strings.ToLower(cfg.Encoder.CallerEncoder),
+		errorEncoder:        strings.ToLower(cfg.Encoder.ErrorEncoder),
+		useTextMarshaler:    cfg.Encoder.UseTextMarshaler,
+		useStringer:         cfg.Encoder.UseStringer,
+		layout:              layout,
 	}
+	return &textEncoder{
+		textSettings: settings,
+		shared:       newSharedTextSettings(settings),
+		buf:          _pool.Get(),
+		dottedKeys:   cfg.Encoder.DottedKeys,
+	}
+}
+
+// secretScanner returns the scanner of the encoder, nil means scanning is disabled
+func (enc *textEncoder) secretScanner() *secretScanner {
+	return enc.shared.load().scanner
+}
+
+// updateSettings copies the latest settings, changes the copy and swaps it in atomically,
+// the snapshots being used by the other goroutines are never changed,
+// the new settings are used by the entries encoded after the swap, including the ones of the clones made by With()
+func (enc *textEncoder) updateSettings(update func(settings *textSettings)) {
+	enc.shared.update(update)
+}
+
+// detachSettings gives the encoder its own copy of the shared settings
+func (enc *textEncoder) detachSettings() {
+	enc.shared = newSharedTextSettings(enc.shared.load())
 }
 
 // SetTimeFormat sets the time format to the encoder
-func (enc *textEncoder) SetTimeFormat(timeFormat string) {
-	enc.TimeFormat = timeFormat
+func (enc *textEncoder) SetTimeFormat(timeFormat string) error {
+	enc.updateSettings(func(settings *textSettings) {
+		settings.TimeFormat = getTimeFormat(timeFormat)
+	})
+	return nil
+}
+
+// SetCallerEncoder sets the caller encoder to the encoder
+func (enc *textEncoder) SetCallerEncoder(callerEncoder string) error {
+	err := validateCallerEncoder(callerEncoder)
+	if err != nil {
+		return err
+	}
+
+	enc.updateSettings(func(settings *textSettings) {
+		settings.callerEncoder = strings.ToLower(callerEncoder)
+	})
+	return nil
 }
 
 // SetSeperator sets the seperator to the encoder
-func (enc *textEncoder) SetSeperator(seperator string) {
-	enc.Seperator = seperator
+func (enc *textEncoder) SetSeperator(seperator string) error {
+	enc.updateSettings(func(settings *textSettings) {
+		settings.Seperator = seperator
+	})
+	return nil
 }
 
 // SetDisableDoubleQuotes disables wrapping log content with double quotes
-func (enc *textEncoder) SetDisableDoubleQuotes(disableDoubleQuotes bool) {
-	enc.DisableDoubleQuotes = disableDoubleQuotes
+func (enc *textEncoder) SetDisableDoubleQuotes(disableDoubleQuotes bool) error {
+	enc.updateSettings(func(settings *textSettings) {
+		settings.DisableDoubleQuotes = disableDoubleQuotes
+	})
+	return nil
 }
 
 // SetDisableEscape disables escaping special characters of log content like \n,\r...
-func (enc *textEncoder) SetDisableEscape(disableEscape bool) {
-	enc.DisableEscape = disableEscape
+func (enc *textEncoder) SetDisableEscape(disableEscape bool) error {
+	enc.updateSettings(func(settings *textSettings) {
+		settings.DisableEscape = disableEscape
+	})
+	return nil
 }
 
 func (enc *textEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return nil
+	}
 	enc.addKey(key)
 	return enc.AppendArray(arr)
 }
 
 func (enc *textEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return nil
+	}
+	if enc.dottedKeys {
+		// the fields of the object are added with the key as the prefix
+		namespace := enc.namespace
+		enc.namespace += key + "."
+		err := obj.MarshalLogObject(enc)
+		enc.namespace = namespace
+		return err
+	}
 	enc.addKey(key)
 	return enc.AppendObject(obj)
 }
@@ -215,31 +431,55 @@ func (enc *textEncoder) AddBinary(key string, val []byte) {
 }
 
 func (enc *textEncoder) AddByteString(key string, val []byte) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, string(val))
+		return
+	}
 	enc.addKey(key)
 	enc.AppendByteString(val)
 }
 
 func (enc *textEncoder) AddBool(key string, val bool) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
 	enc.addKey(key)
 	enc.AppendBool(val)
 }
 
 func (enc *textEncoder) AddComplex128(key string, val complex128) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
 	enc.addKey(key)
 	enc.AppendComplex128(val)
 }
 
 func (enc *textEncoder) AddDuration(key string, val time.Duration) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
 	enc.addKey(key)
 	enc.AppendDuration(val)
 }
 
 func (enc *textEncoder) AddFloat64(key string, val float64) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, strconv.FormatFloat(val, 'g', -1, 64))
+		return
+	}
 	enc.addKey(key)
 	enc.AppendFloat64(val)
 }
 
 func (enc *textEncoder) AddInt64(key string, val int64) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, strconv.FormatInt(val, 10))
+		return
+	}
 	enc.addKey(key)
 	enc.AppendInt64(val)
 }
@@ -254,57 +494,103 @@ func (enc *textEncoder) resetReflectBuf() {
 }
 
 func (enc *textEncoder) AddReflected(key string, obj interface{}) error {
+	if !enc.isRedacted(key) {
+		if encode := getTypeEncoder(obj, enc.useTextMarshaler, enc.useStringer); encode != nil {
+			enc.addKey(key)
+			encode(obj, enc)
+			return nil
+		}
+	}
 	enc.resetReflectBuf()
 	err := enc.reflectEnc.Encode(obj)
 	if err != nil {
 		return err
 	}
 	enc.reflectBuf.TrimNewline()
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, string(enc.reflectBuf.Bytes()))
+		return nil
+	}
 	enc.addKey(key)
 	enc.AppendByteString(enc.reflectBuf.Bytes())
 	return nil
 }
 
 func (enc *textEncoder) OpenNamespace(key string) {
+	if enc.isRedacted(key) {
+		enc.redactAll = true
+	}
+	if enc.dottedKeys {
+		enc.namespace += key + "."
+		return
+	}
 	enc.addKey(key)
 	enc.buf.AppendByte('{')
 	enc.openNamespaces++
 }
 
 func (enc *textEncoder) AddString(key, val string) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, val)
+		return
+	}
 	enc.addKey(key)
 	enc.AppendString(val)
 }
 
 func (enc *textEncoder) AddTime(key string, val time.Time) {
+	if enc.isRedacted(key) {
+		enc.addMasked(key)
+		return
+	}
 	enc.addKey(key)
 	enc.AppendTime(val)
 }
 
 func (enc *textEncoder) AddUint64(key string, val uint64) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, strconv.FormatUint(val, 10))
+		return
+	}
 	enc.addKey(key)
 	enc.AppendUint64(val)
 }
 
 func (enc *textEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
 	enc.addElementSeparator()
-	ne := enc.cloned()
-	ne.buf.AppendByte('[')
-	err := arr.MarshalLogArray(ne)
-	ne.buf.AppendByte(']')
-	enc.AppendByteString(ne.buf.Bytes())
+	ne := enc.nested()
+	err := ne.marshalArray(arr)
+	enc.appendByteString(ne.buf.Bytes())
 	ne.buf.Free()
 	putTextEncoder(ne)
 	return err
 }
 
+// marshalArray appends the elements of the array wrapped with brackets,
+// the elements which exceed the limit are replaced with the truncated marker
+func (enc *textEncoder) marshalArray(arr zapcore.ArrayMarshaler) error {
+	enc.buf.AppendByte('[')
+	var err error
+	if la := enc.fieldLimiter().limitArray(enc); la != nil {
+		err = arr.MarshalLogArray(la)
+		if marker := la.marker(); marker != "" {
+			enc.addElementSeparator()
+			enc.safeAddStringWithQuote(marker)
+		}
+	} else {
+		err = arr.MarshalLogArray(enc)
+	}
+	enc.buf.AppendByte(']')
+	return err
+}
+
 func (enc *textEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
 	enc.addElementSeparator()
-	ne := enc.cloned()
+	ne := enc.nested()
 	ne.buf.AppendByte('{')
 	err := obj.MarshalLogObject(ne)
 	ne.buf.AppendByte('}')
-	enc.AppendByteString(ne.buf.Bytes())
+	enc.appendByteString(ne.buf.Bytes())
 	ne.buf.Free()
 	putTextEncoder(ne)
 	return err
@@ -317,7 +603,13 @@ func (enc *textEncoder) AppendBool(val bool) {
 
 func (enc *textEncoder) AppendByteString(val []byte) {
 	enc.addElementSeparator()
-	if !enc.needDoubleQuotes(string(val)) {
+	enc.appendByteString(enc.fieldLimiter().truncateFieldBytes(val))
+}
+
+// appendByteString appends the byte string without the element separator and the size limit,
+// the encoded arrays and objects are appended by it, as they are limited by their elements
+func (enc *textEncoder) appendByteString(val []byte) {
+	if !enc.needDoubleQuotesBytes(val) {
 		enc.safeAddByteString(val)
 		return
 	}
@@ -351,6 +643,10 @@ func (enc *textEncoder) AppendInt64(val int64) {
 }
 
 func (enc *textEncoder) AppendReflected(val interface{}) error {
+	if encode := getTypeEncoder(val, enc.useTextMarshaler, enc.useStringer); encode != nil {
+		encode(val, enc)
+		return nil
+	}
 	enc.resetReflectBuf()
 	err := enc.reflectEnc.Encode(val)
 	if err != nil {
@@ -363,7 +659,7 @@ func (enc *textEncoder) AppendReflected(val interface{}) error {
 
 func (enc *textEncoder) AppendString(val string) {
 	enc.addElementSeparator()
-	enc.safeAddStringWithQuote(val)
+	enc.safeAddStringWithQuote(enc.fieldLimiter().truncateField(val))
 }
 
 func (enc *textEncoder) AppendTime(val time.Time) {
@@ -387,6 +683,27 @@ func (enc *textEncoder) endQuoteFiled() {
 	enc.buf.AppendByte(']')
 }
 
+// beginField begins a field, the bracket is opened by addKey() if the keys are dotted,
+// as a field may be flattened into several bracketed keys, or into nothing
+func (enc *textEncoder) beginField() {
+	if enc.dottedKeys {
+		return
+	}
+	enc.beginQuoteFiled()
+}
+
+// endField ends a field, it closes the bracket opened by beginField() or addKey()
+func (enc *textEncoder) endField() {
+	if !enc.dottedKeys {
+		enc.endQuoteFiled()
+		return
+	}
+	if enc.fieldOpen {
+		enc.endQuoteFiled()
+		enc.fieldOpen = false
+	}
+}
+
 func (enc *textEncoder) AppendUint64(val uint64) {
 	enc.addElementSeparator()
 	enc.buf.AppendUint(val)
@@ -424,20 +741,50 @@ func (enc *textEncoder) Clone() zapcore.Encoder {
 
 func (enc *textEncoder) cloned() *textEncoder {
 	clone := getTextEncoder()
-	clone.EncoderConfig = enc.EncoderConfig
-	clone.spaced = enc.spaced
+	// the settings are shared, as the snapshots are never changed after being shared
+	clone.textSettings = enc.shared.load()
+	clone.shared = enc.shared
 	clone.openNamespaces = enc.openNamespaces
-	clone.disableErrorVerbose = enc.disableErrorVerbose
+	clone.redactAll = enc.redactAll
 	clone.buf = _pool.Get()
-	clone.TimeFormat = enc.TimeFormat
-	clone.Seperator = enc.Seperator
-	clone.DisableDoubleQuotes = enc.DisableDoubleQuotes
-	clone.DisableEscape = enc.DisableEscape
+	clone.dottedKeys = enc.dottedKeys
+	clone.namespace = enc.namespace
 	return clone
 }
 
+// fieldLimiter returns the limiter of the field values, it is nil while the header items are encoded
+func (enc *textEncoder) fieldLimiter() *limiter {
+	if enc.noLimit {
+		return nil
+	}
+
+	return enc.limiter
+}
+
+// nested returns a clone for encoding the arrays and the objects in the arrays,
+// the keys in them are not dotted
+func (enc *textEncoder) nested() *textEncoder {
+	ne := enc.cloned()
+	// the nested values are encoded with the settings of the value being encoded
+	ne.textSettings = enc.textSettings
+	ne.dottedKeys = false
+	ne.namespace = ""
+	return ne
+}
+
 func (enc *textEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
 	final := enc.cloned()
+	if final.layout != nil {
+		final.encodeLayoutEntry(enc, ent, fields)
+		final.addLineEnding()
+		final.addStackFrames(ent)
+		final.truncateEntry()
+
+		ret := final.buf
+		putTextEncoder(final)
+		return ret, nil
+	}
+	final.noLimit = true
 	if final.TimeKey != "" {
 		final.beginQuoteFiled()
 		final.AppendTime(ent.Time)
@@ -453,6 +800,11 @@ func (enc *textEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (
 		}
 		final.endQuoteFiled()
 	}
+	if ent.Caller.Defined && final.FunctionKey != "" {
+		final.beginQuoteFiled()
+		final.AppendString(ent.Caller.Function)
+		final.endQuoteFiled()
+	}
 	if final.LevelKey != "" {
 		final.beginQuoteFiled()
 		cur := final.buf.Len()
@@ -480,14 +832,15 @@ func (enc *textEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (
 		}
 		final.endQuoteFiled()
 	}
+	final.noLimit = false
 	// add seperator
 	if final.Seperator != "" {
 		final.buf.AppendString(final.Seperator)
 	}
 	// add Message
-	if len(ent.Message) > 0 {
+	if len(ent.Message) > 0 && final.MessageKey != "" {
 		final.beginQuoteFiled()
-		final.AppendString(ent.Message)
+		final.safeAddStringWithQuote(final.limiter.truncateMessage(ent.Message))
 		final.endQuoteFiled()
 	}
 	if enc.buf.Len() > 0 {
@@ -496,25 +849,72 @@ func (enc *textEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (
 	}
 	final.addFields(fields)
 	final.closeOpenNamespaces()
-	if ent.Stack != "" && final.StacktraceKey != "" {
-		final.beginQuoteFiled()
+	final.addStack(ent)
+	final.addLineEnding()
+	final.addStackFrames(ent)
+	final.truncateEntry()
+
+	ret := final.buf
+	putTextEncoder(final)
+	return ret, nil
+}
+
+// addStack adds the stack of the entry to the buffer,
+// the structured stack is added after the line ending by addStackFrames()
+func (enc *textEncoder) addStack(ent zapcore.Entry) {
+	if ent.Stack != "" && enc.StacktraceKey != "" {
 		stack := ent.Stack
+		if enc.stackFormatter != nil {
+			frames, ok := enc.stackFormatter.frames(ent.Stack)
+			if ok && enc.stackFormatter.structured {
+				return
+			}
+			if ok {
+				stack = frames.String()
+			}
+		}
+		enc.beginField()
 		if enc.DisableEscape {
-			stack = fmt.Sprintf("\n%s\n", ent.Stack)
+			stack = fmt.Sprintf("\n%s\n", stack)
 		}
-		final.AddString(final.StacktraceKey, stack)
-		final.endQuoteFiled()
+		enc.AddString(enc.StacktraceKey, stack)
+		enc.endField()
 	}
+}
 
-	if final.LineEnding != "" {
-		final.buf.AppendString(final.LineEnding)
-	} else {
-		final.buf.AppendString(zapcore.DefaultLineEnding)
+// addStackFrames adds each frame of the structured stack as an indented line
+func (enc *textEncoder) addStackFrames(ent zapcore.Entry) {
+	if ent.Stack == "" || enc.StacktraceKey == "" || enc.stackFormatter == nil || !enc.stackFormatter.structured {
+		return
+	}
+	frames, ok := enc.stackFormatter.frames(ent.Stack)
+	if !ok {
+		return
 	}
+	for _, line := range frames.lines() {
+		enc.buf.AppendString(consoleIndent)
+		enc.safeAddString(line)
+		enc.addLineEnding()
+	}
+}
 
-	ret := final.buf
-	putTextEncoder(final)
-	return ret, nil
+// addLineEnding adds the line ending to the buffer
+func (enc *textEncoder) addLineEnding() {
+	enc.buf.AppendString(enc.lineEnding())
+}
+
+// lineEnding returns the line ending of the encoder
+func (enc *textEncoder) lineEnding() string {
+	if enc.LineEnding != "" {
+		return enc.LineEnding
+	}
+
+	return zapcore.DefaultLineEnding
+}
+
+// truncateEntry cuts the encoded entry if it exceeds the max entry size
+func (enc *textEncoder) truncateEntry() {
+	enc.limiter.truncateEntry(enc.buf, enc.lineEnding())
 }
 
 func (enc *textEncoder) truncate() {
@@ -528,6 +928,14 @@ func (enc *textEncoder) closeOpenNamespaces() {
 }
 
 func (enc *textEncoder) addKey(key string) {
+	if enc.dottedKeys {
+		enc.endField()
+		enc.beginQuoteFiled()
+		enc.fieldOpen = true
+		enc.safeAddStringWithQuote(enc.namespace + key)
+		enc.buf.AppendByte('=')
+		return
+	}
 	enc.addElementSeparator()
 	enc.safeAddStringWithQuote(key)
 	enc.buf.AppendByte('=')
@@ -564,6 +972,14 @@ func (enc *textEncoder) appendFloat(val float64, bitSize int) {
 // Unlike the standard library's encoder, it doesn't attempt to protect the
 // user from browser vulnerabilities or JSONP-related problems.
 func (enc *textEncoder) safeAddString(s string) {
+	if enc.scanner != nil {
+		s = enc.scanner.scan(s)
+	}
+	enc.escapeString(s)
+}
+
+// escapeString escapes the string like safeAddString() without scanning the secrets.
+func (enc *textEncoder) escapeString(s string) {
 	for i := 0; i < len(s); {
 		if enc.tryAddRuneSelf(s[i]) {
 			i++
@@ -593,6 +1009,11 @@ func (enc *textEncoder) safeAddStringWithQuote(s string) {
 
 // safeAddByteString is no-alloc equivalent of safeAddString(string(s)) for s []byte.
 func (enc *textEncoder) safeAddByteString(s []byte) {
+	if enc.scanner != nil {
+		// the secrets are scanned as a string
+		enc.safeAddString(string(s))
+		return
+	}
 	for i := 0; i < len(s); {
 		if enc.tryAddRuneSelf(s[i]) {
 			i++
@@ -610,16 +1031,32 @@ func (enc *textEncoder) safeAddByteString(s []byte) {
 
 // See [log-fileds](https://github.com/tikv/rfcs/blob/master/text/2018-12-19-unified-log-format.md#log-fields-section).
 func (enc *textEncoder) needDoubleQuotes(s string) bool {
-	for i := 0; i < len(s); {
-		b := s[i]
-		if b <= 0x20 {
+	for i := 0; i < len(s); i++ {
+		if needDoubleQuotesByte(s[i]) {
 			return true
 		}
-		switch b {
-		case '\\', '"', '[', ']', '=':
+	}
+	return false
+}
+
+// needDoubleQuotesBytes is no-alloc equivalent of needDoubleQuotes(string(s)) for s []byte.
+func (enc *textEncoder) needDoubleQuotesBytes(s []byte) bool {
+	for i := 0; i < len(s); i++ {
+		if needDoubleQuotesByte(s[i]) {
 			return true
 		}
-		i++
+	}
+	return false
+}
+
+// needDoubleQuotesByte returns true if the value containing b should be quoted
+func needDoubleQuotesByte(b byte) bool {
+	if b <= 0x20 {
+		return true
+	}
+	switch b {
+	case '\\', '"', '[', ']', '=', '{', '}':
+		return true
 	}
 	return false
 }
@@ -690,28 +1127,80 @@ func (enc *textEncoder) addFields(fields []zapcore.Field) {
 			enc.encodeError(f)
 			continue
 		}
-		enc.beginQuoteFiled()
+		enc.beginField()
 		f.AddTo(enc)
-		enc.endQuoteFiled()
+		enc.endField()
 	}
 }
 
 func (enc *textEncoder) encodeError(f zapcore.Field) {
 	err := f.Interface.(error)
 	basic := err.Error()
-	enc.beginQuoteFiled()
+	if enc.isRedacted(f.Key) {
+		// the verbose error is omitted, as it contains the basic error message
+		enc.beginField()
+		enc.addRedacted(f.Key, basic)
+		enc.endField()
+		return
+	}
+	enc.beginField()
 	enc.AddString(f.Key, basic)
-	enc.endQuoteFiled()
+	enc.endField()
 	if enc.disableErrorVerbose {
 		return
 	}
+	if enc.errorEncoder == ErrorEncoderChain {
+		enc.beginField()
+		_ = enc.AddArray(f.Key+errorChainSuffix, newErrorChain(err, enc.stackFormatter))
+		enc.endField()
+		return
+	}
 	if e, isFormatter := err.(fmt.Formatter); isFormatter {
-		verbose := fmt.Sprintf("%+v", e)
-		if verbose != basic {
+		// the verbose error is formatted into a pooled buffer instead of a new string
+		verbose := _pool.Get()
+		_, _ = fmt.Fprintf(verbose, "%+v", e)
+		if string(verbose.Bytes()) != basic {
 			// This is a rich error type, like those produced by github.com/pkg/errors.
-			enc.beginQuoteFiled()
-			enc.AddString(f.Key+"Verbose", verbose)
-			enc.endQuoteFiled()
+			enc.beginField()
+			enc.addStringBytes(f.Key+"Verbose", verbose.Bytes())
+			enc.endField()
 		}
+		verbose.Free()
 	}
 }
+
+// addStringBytes is no-alloc equivalent of AddString(key, string(val)) for val []byte,
+// unlike AddByteString, the value is not quoted if DisableDoubleQuotes is true
+func (enc *textEncoder) addStringBytes(key string, val []byte) {
+	if enc.isRedacted(key) {
+		enc.addRedacted(key, string(val))
+		return
+	}
+	enc.addKey(key)
+	enc.addElementSeparator()
+	val = enc.fieldLimiter().truncateFieldBytes(val)
+	if enc.DisableDoubleQuotes || !enc.needDoubleQuotesBytes(val) {
+		enc.safeAddByteString(val)
+		return
+	}
+	enc.buf.AppendByte('"')
+	enc.safeAddByteString(val)
+	enc.buf.AppendByte('"')
+}
+
+// isRedacted returns true if the value of the key should be redacted
+func (enc *textEncoder) isRedacted(key string) bool {
+	return enc.redactor != nil && (enc.redactAll || enc.redactor.match(key))
+}
+
+// addRedacted adds the key with the redacted value
+func (enc *textEncoder) addRedacted(key, val string) {
+	enc.addKey(key)
+	enc.AppendString(enc.redactor.redact(val))
+}
+
+// addMasked adds the key with the mask, it is used for the values which could not be hashed
+func (enc *textEncoder) addMasked(key string) {
+	enc.addKey(key)
+	enc.AppendString(enc.redactor.mask)
+}